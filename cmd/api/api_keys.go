@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kubil6y/dukkan-go/internal/data"
+	"github.com/kubil6y/dukkan-go/internal/validator"
+)
+
+// createAPIKeyHandler() creates a key with a subset of the permissions of
+// the admin, the plain key is only shown in this response.
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input createAPIKeyDTO
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	admin := app.getUserContext(r)
+	for _, code := range input.Permissions {
+		v.Check(admin.Role.HasPermission(code), "permissions", "can not grant "+code+" which you do not have")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetByCodes(input.Permissions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	key := data.APIKey{
+		Name:        input.Name,
+		Expiry:      input.Expiry,
+		CreatedByID: admin.ID,
+		Permissions: permissions,
+	}
+	if err := app.models.APIKeys.Insert(&key); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{"api_key": key}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusCreated, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) getAllAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.models.APIKeys.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{"api_keys": keys}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.parseIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	key, err := app.models.APIKeys.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.models.APIKeys.Delete(key); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{"message": "success"}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/kubil6y/dukkan-go/internal/data"
+	"github.com/kubil6y/dukkan-go/internal/validator"
+)
+
+// getAuditEventsHandler() lists the audit log, ?action= and ?email= filter it.
+func (app *application) getAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	p := data.NewPaginate(r, v, 25, 1)
+
+	if data.ValidatePaginate(p, v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	qs := r.URL.Query()
+	action := app.readString(qs, "action", "")
+	email := app.readString(qs, "email", "")
+
+	events, metadata, err := app.models.Audit.GetAll(p, action, email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{
+		"events":   events,
+		"metadata": metadata,
+	}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
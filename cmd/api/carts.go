@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kubil6y/dukkan-go/internal/data"
+	"github.com/kubil6y/dukkan-go/internal/validator"
+)
+
+// writeCart() reloads the cart, so the response shows live prices and stock.
+func (app *application) writeCart(w http.ResponseWriter, r *http.Request, userID int64) {
+	cart, err := app.models.Carts.GetForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{"cart": data.NewCartView(cart)}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) getCartHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserContext(r)
+	app.writeCart(w, r, user.ID)
+}
+
+func (app *application) addCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	var input addCartItemDTO
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	product, err := app.models.Products.GetByID(input.ProductID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	variants, err := app.models.Variants.GetAllByProductID(product.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	switch {
+	case len(variants) > 0 && input.VariantID == 0:
+		v.AddError("variant_id", "must be provided")
+	case len(variants) == 0 && input.VariantID != 0:
+		v.AddError("variant_id", "product does not have variants")
+	case input.VariantID != 0:
+		found := false
+		for _, variant := range variants {
+			found = found || variant.ID == input.VariantID
+		}
+		v.Check(found, "variant_id", "does not belong to the product")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.getUserContext(r)
+	cart, err := app.models.Carts.GetForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// adding a product that is already in the cart increases its quantity.
+	if err := app.models.Carts.AddItem(cart, product.ID, input.VariantID, input.Quantity); err != nil {
+		switch {
+		case errors.Is(err, data.ErrCartItemQuantity):
+			v.AddError("quantity", fmt.Sprintf("must be a maximum of %d including the quantity in the cart", data.MaxCartItemQuantity))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeCart(w, r, user.ID)
+}
+
+func (app *application) updateCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	// cart item id
+	id, err := app.parseIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var input updateCartItemDTO
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.getUserContext(r)
+	cart, err := app.models.Carts.GetForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	item, err := app.models.Carts.GetItemByID(cart, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.models.Carts.SetItem(cart, item.ProductID, item.VariantID, input.Quantity); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeCart(w, r, user.ID)
+}
+
+func (app *application) deleteCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	// cart item id
+	id, err := app.parseIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.getUserContext(r)
+	cart, err := app.models.Carts.GetForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	item, err := app.models.Carts.GetItemByID(cart, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.models.Carts.DeleteItem(item); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeCart(w, r, user.ID)
+}
+
+func (app *application) clearCartHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserContext(r)
+	cart, err := app.models.Carts.GetForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.models.Carts.Clear(cart); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeCart(w, r, user.ID)
+}
+
+func (app *application) checkoutHandler(w http.ResponseWriter, r *http.Request) {
+	var input checkoutDTO
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.getUserContext(r)
+	cart, err := app.models.Carts.GetForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	order, err := app.models.Carts.Checkout(cart, sanitize(input.PaymentMethod))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEmptyCart):
+			app.emptyCartResponse(w, r)
+		case errors.Is(err, data.ErrOutOfStock):
+			app.outOfStockResponse(w, r)
+		case errors.Is(err, data.ErrCurrencyMismatch):
+			app.badRequestResponse(w, r, errors.New("order items must have the same currency"))
+		case errors.Is(err, data.ErrVariantRequired), errors.Is(err, data.ErrInvalidVariant):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	e := envelope{"order": order}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/kubil6y/dukkan-go/internal/data"
+	"github.com/kubil6y/dukkan-go/internal/email"
+	"github.com/kubil6y/dukkan-go/internal/oidc"
+	"go.uber.org/zap"
+	"golang.org/x/term"
+)
+
+const commandsUsage = `usage: api [flags] <command>
+
+commands:
+  serve            run the API server (default)
+  seed             fill a development database with fake data
+  migrate          apply or revert database migrations
+  create-admin     create an activated admin user
+  reset-password   set a new password for a user`
+
+// newApplication() connects to the database and sets up everything the
+// commands share, close() has to be called when the command is done.
+func newApplication(cfg config, logger *zap.SugaredLogger) (*application, error) {
+	db, err := connectDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.db.schemaCheck {
+		if err := checkSchema(db); err != nil {
+			return nil, err
+		}
+	}
+	if err := syncPermissions(db); err != nil {
+		return nil, err
+	}
+	data.SetCursorKey([]byte(cfg.cursor.secret))
+	if err := data.SetPasswordHashing(cfg.passwords); err != nil {
+		return nil, err
+	}
+
+	providers := make(map[string]oidc.Provider)
+	for _, providerConfig := range cfg.oidc.providers {
+		providers[providerConfig.Name] = oidc.NewProvider(providerConfig)
+	}
+
+	mailer, err := email.NewSender(newMailer(cfg, logger), cfg.smtp.sender, cfg.domain)
+	if err != nil {
+		return nil, err
+	}
+
+	return &application{
+		config:    cfg,
+		logger:    logger,
+		version:   version,
+		models:    data.NewModels(db),
+		mailer:    mailer,
+		providers: providers,
+		db:        db,
+		shutdown:  make(chan struct{}),
+	}, nil
+}
+
+// close() closes the database connections.
+func (app *application) close() error {
+	sqlDB, err := app.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+func serveCommand(cfg config, logger *zap.SugaredLogger) error {
+	app, err := newApplication(cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	if err := app.serve(); err != nil {
+		return fmt.Errorf("%s server failed: %w", cfg.env, err)
+	}
+
+	if err := app.close(); err != nil {
+		return err
+	}
+	app.logger.Info("database connections closed")
+	return nil
+}
+
+// ensureRoles() creates the admin and user roles when they are missing, new
+// users get the role with id 2 so the admin role has to come first.
+func (app *application) ensureRoles() error {
+	for _, name := range []string{"admin", "user"} {
+		_, err := app.models.Roles.GetByName(name)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			if err := app.models.Roles.Insert(&data.Role{Name: name}); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		}
+	}
+	return app.models.Permissions.Sync("admin")
+}
+
+// $ go run ./cmd/api create-admin -email=admin@example.com
+func createAdminCommand(cfg config, logger *zap.SugaredLogger, args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	emailAddress := flags.String("email", "", "Email of the admin")
+	firstName := flags.String("first-name", "admin", "First name of the admin")
+	lastName := flags.String("last-name", "admin", "Last name of the admin")
+	address := flags.String("address", "", "Address of the admin")
+	flags.Parse(args)
+
+	if !govalidator.IsEmail(*emailAddress) {
+		return errors.New("create-admin: -email must be a valid email address")
+	}
+
+	app, err := newApplication(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer app.close()
+
+	user := &data.User{
+		FirstName:   *firstName,
+		LastName:    *lastName,
+		Email:       strings.ToLower(*emailAddress),
+		Address:     *address,
+		IsActivated: true,
+	}
+
+	_, err = app.models.Users.GetByEmail(user.Email)
+	switch {
+	case err == nil:
+		return fmt.Errorf("create-admin: %s already exists, use reset-password to change the password", user.Email)
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return err
+	}
+
+	if err := app.ensureRoles(); err != nil {
+		return err
+	}
+	role, err := app.models.Roles.GetByName("admin")
+	if err != nil {
+		return err
+	}
+	user.RoleID = role.ID
+
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	if err := user.SetPassword(password); err != nil {
+		return err
+	}
+
+	if err := app.models.Users.Insert(user); err != nil {
+		if errors.Is(err, data.ErrDuplicateRecord) {
+			return fmt.Errorf("create-admin: %s already exists", user.Email)
+		}
+		return err
+	}
+	fmt.Printf("created admin %s (id %d)\n", user.Email, user.ID)
+	return nil
+}
+
+// $ go run ./cmd/api reset-password -email=admin@example.com
+func resetPasswordCommand(cfg config, logger *zap.SugaredLogger, args []string) error {
+	flags := flag.NewFlagSet("reset-password", flag.ExitOnError)
+	emailAddress := flags.String("email", "", "Email of the user")
+	flags.Parse(args)
+
+	if *emailAddress == "" {
+		return errors.New("reset-password: -email must be provided")
+	}
+
+	app, err := newApplication(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer app.close()
+
+	user, err := app.models.Users.GetByEmail(strings.ToLower(*emailAddress))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return fmt.Errorf("reset-password: %s does not exist", *emailAddress)
+		}
+		return err
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	if err := user.SetPassword(password); err != nil {
+		return err
+	}
+
+	// the user is logged out everywhere and a locked account is unlocked.
+	if err := app.models.Tokens.ResetPassword(user); err != nil {
+		return err
+	}
+	if err := app.models.LoginAttempts.Reset(user.Email); err != nil {
+		return err
+	}
+	fmt.Printf("password of %s is reset\n", user.Email)
+	return nil
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// readPassword() prompts for a password twice, it is not echoed when stdin is
+// a terminal, otherwise it is read line by line so it can be piped in.
+func readPassword() (string, error) {
+	password, err := promptPassword("Password: ")
+	if err != nil {
+		return "", err
+	}
+	confirm, err := promptPassword("Confirm password: ")
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case password != confirm:
+		return "", errors.New("passwords do not match")
+	case len(password) < 6:
+		return "", errors.New("password must be at least six characters")
+	}
+	return password, nil
+}
+
+func promptPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(b), err
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
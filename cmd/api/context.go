@@ -9,7 +9,10 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey  = contextKey("user")
+	tokenContextKey = contextKey("token")
+)
 
 func (app *application) setUserContext(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -23,3 +26,15 @@ func (app *application) getUserContext(r *http.Request) *data.User {
 	}
 	return user
 }
+
+func (app *application) setTokenContext(r *http.Request, token *data.Token) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// getTokenContext() returns the authentication token of the request,
+// nil for anonymous users.
+func (app *application) getTokenContext(r *http.Request) *data.Token {
+	token, _ := r.Context().Value(tokenContextKey).(*data.Token)
+	return token
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/kubil6y/dukkan-go/internal/data"
+	"github.com/kubil6y/dukkan-go/internal/migrations"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -14,16 +18,30 @@ func connectDatabase(cfg config) (*gorm.DB, error) {
 	return db, nil
 }
 
-func autoMigrate(db *gorm.DB) {
-	db.AutoMigrate(
-		&data.User{},
-		&data.Role{},
-		&data.Token{},
-		&data.Product{},
-		&data.Category{},
-		&data.Review{},
-		&data.Rating{},
-		&data.Order{},
-		&data.OrderItem{},
-	)
+// checkSchema() refuses to start the server against a database
+// which has pending migrations.
+func checkSchema(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	migrator, err := migrations.New(sqlDB)
+	if err != nil {
+		return err
+	}
+
+	pending, err := migrator.Pending(context.Background())
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is out of date, %d migrations are pending, run: migrate up", len(pending))
+	}
+	return nil
+}
+
+// syncPermissions() creates the permissions checked by requirePermission(),
+// the admin role is granted all of them.
+func syncPermissions(db *gorm.DB) error {
+	return data.PermissionModel{DB: db}.Sync("admin")
 }
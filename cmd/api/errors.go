@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // logError() logs errors
@@ -64,6 +67,12 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// 401 - StatusUnauthorized
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or expired API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 // 401 - StatusUnauthorized
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
@@ -106,6 +115,74 @@ func (app *application) outOfStockResponse(w http.ResponseWriter, r *http.Reques
 	app.errorResponse(w, r, http.StatusBadRequest, message)
 }
 
+// 403 - StatusForbidden
+func (app *application) twoFactorRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must enable two factor authentication to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// 401 - StatusUnauthorized
+func (app *application) invalidTwoFactorCodeResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid two factor authentication code"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// 403 - StatusForbidden
+func (app *application) unverifiedEmailResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the identity provider has not verified your email address"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// 409 - StatusConflict
+func (app *application) invalidTransitionResponse(w http.ResponseWriter, r *http.Request, from, to string) {
+	message := fmt.Sprintf("order can not move from %s to %s", from, to)
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// 409 - StatusConflict
+func (app *application) idempotencyKeyInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a request with this idempotency key is still being processed"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// 409 - StatusConflict
+func (app *application) identityLinkRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "an account with this email address exists, log in and sign in with the provider again to link it"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// 409 - StatusConflict
+func (app *application) identityLinkedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this identity is linked to another account"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// 409 - StatusConflict
+func (app *application) jobNotDeadResponse(w http.ResponseWriter, r *http.Request) {
+	message := "only dead jobs can be retried"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// 422 - StatusUnprocessableEntity
+func (app *application) idempotencyKeyMismatchResponse(w http.ResponseWriter, r *http.Request) {
+	message := "idempotency key was already used with a different request"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+}
+
+// 400 - StatusBadRequest
+func (app *application) emptyCartResponse(w http.ResponseWriter, r *http.Request) {
+	message := "cart is empty"
+	app.errorResponse(w, r, http.StatusBadRequest, message)
+}
+
+// 429 - StatusTooManyRequests
+func (app *application) loginLockedResponse(w http.ResponseWriter, r *http.Request, until time.Time) {
+	retryAfter := int(math.Ceil(time.Until(until).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 // 429 - StatusTooManyRequests
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
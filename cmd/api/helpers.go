@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -101,6 +102,18 @@ func (app *application) parseSlugParam(r *http.Request) string {
 	return s
 }
 
+func (app *application) parseProviderParam(r *http.Request) string {
+	params := httprouter.ParamsFromContext(r.Context())
+	s := params.ByName("provider")
+	return s
+}
+
+func (app *application) parseCodeParam(r *http.Request) string {
+	params := httprouter.ParamsFromContext(r.Context())
+	s := params.ByName("code")
+	return s
+}
+
 func (app *application) parseIDParam(r *http.Request) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 	s := params.ByName("id")
@@ -111,20 +124,13 @@ func (app *application) parseIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
-func (app *application) background(fn func()) {
-	app.wg.Add(1)
-	go func() {
-		defer app.wg.Done()
-
-		// recover...
-		defer func() {
-			if err := recover(); err != nil {
-				app.logger.Errorf("%s", err)
-			}
-		}()
-
-		fn()
-	}()
+// clientIP() returns the IP address of the client without the port.
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
 }
 
 // QUERY STRING METHODS BEGIN //////////////////////////////
@@ -149,6 +155,45 @@ func (app *application) readInt(qs url.Values, v *validator.Validator, key strin
 	return i
 }
 
+func (app *application) readInt64Ptr(qs url.Values, v *validator.Validator, key string) *int64 {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		v.AddError(key, "invalid value")
+		return nil
+	}
+	return &i
+}
+
+func (app *application) readFloat(qs url.Values, v *validator.Validator, key string, defaultValue float64) float64 {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		v.AddError(key, "invalid value")
+		return defaultValue
+	}
+	return f
+}
+
+func (app *application) readBool(qs url.Values, v *validator.Validator, key string, defaultValue bool) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "invalid value")
+		return defaultValue
+	}
+	return b
+}
+
 func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
 	csv := qs.Get(key)
 	if csv == "" {
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kubil6y/dukkan-go/internal/data"
+	"github.com/kubil6y/dukkan-go/internal/jobs"
+	"github.com/kubil6y/dukkan-go/internal/validator"
+)
+
+// Job kinds, every kind has a payload struct and a handler registered in registerJobs().
+const (
+	jobActivationEmail    = "email.activation"
+	jobPasswordResetEmail = "email.password-reset"
+	jobEmailChangeEmail   = "email.email-change"
+	jobEmailChangeNotice  = "email.email-change-notice"
+	jobKeepLastFiveTokens = "tokens.keep-last-five"
+	defaultJobMaxAttempts = 5
+)
+
+// Payloads never hold codes, jobs.payload is stored in plaintext. The
+// handlers create the token right before sending it, every attempt sends
+// a new code.
+
+type activationEmailJob struct {
+	UserID int64 `json:"user_id"`
+}
+
+type passwordResetEmailJob struct {
+	UserID int64 `json:"user_id"`
+}
+
+type emailChangeEmailJob struct {
+	UserID   int64  `json:"user_id"`
+	NewEmail string `json:"new_email"`
+}
+
+type emailChangeNoticeJob struct {
+	UserID   int64  `json:"user_id"`
+	NewEmail string `json:"new_email"`
+}
+
+type keepLastFiveTokensJob struct {
+	UserID int64 `json:"user_id"`
+}
+
+func (app *application) registerJobs(w *jobs.Worker) {
+	w.Handle(jobActivationEmail, func(ctx context.Context, payload []byte) error {
+		var job activationEmailJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return err
+		}
+		user, err := app.models.Users.GetByID(job.UserID)
+		if err != nil {
+			return err
+		}
+		if user.IsActivated {
+			return nil
+		}
+		token, err := app.models.Tokens.New(user.ID, 1*time.Hour, data.ScopeActivation)
+		if err != nil {
+			return err
+		}
+		return app.mailer.ActivationEmail(ctx, user, token.Plaintext)
+	})
+
+	w.Handle(jobPasswordResetEmail, func(ctx context.Context, payload []byte) error {
+		var job passwordResetEmailJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return err
+		}
+		user, err := app.models.Users.GetByID(job.UserID)
+		if err != nil {
+			return err
+		}
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			return err
+		}
+		return app.mailer.PasswordResetEmail(ctx, user, token.Plaintext)
+	})
+
+	w.Handle(jobEmailChangeEmail, func(ctx context.Context, payload []byte) error {
+		var job emailChangeEmailJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return err
+		}
+		user, err := app.models.Users.GetByID(job.UserID)
+		if err != nil {
+			return err
+		}
+		token, err := app.models.Tokens.NewEmailChange(user.ID, job.NewEmail, 1*time.Hour)
+		if err != nil {
+			// the user changed the email again, that job sends the code.
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		return app.mailer.EmailChangeEmail(ctx, user, job.NewEmail, token.Plaintext)
+	})
+
+	w.Handle(jobEmailChangeNotice, func(ctx context.Context, payload []byte) error {
+		var job emailChangeNoticeJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return err
+		}
+		user, err := app.models.Users.GetByID(job.UserID)
+		if err != nil {
+			return err
+		}
+		return app.mailer.EmailChangeNoticeEmail(ctx, user, job.NewEmail)
+	})
+
+	w.Handle(jobKeepLastFiveTokens, func(ctx context.Context, payload []byte) error {
+		var job keepLastFiveTokensJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return err
+		}
+		return app.models.Tokens.KeepLastFiveSessions(job.UserID)
+	})
+}
+
+// enqueue() stores the job, a failure is only logged since the request
+// itself already succeeded.
+func (app *application) enqueue(r *http.Request, kind string, payload interface{}) {
+	if _, err := app.models.Jobs.Enqueue(kind, payload, defaultJobMaxAttempts); err != nil {
+		app.logError(r, err)
+	}
+}
+
+// getAllJobsHandler() lists the jobs, ?status= and ?kind= filter them.
+func (app *application) getAllJobsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	p := data.NewPaginate(r, v, 25, 1)
+
+	qs := r.URL.Query()
+	status := app.readString(qs, "status", "")
+	kind := app.readString(qs, "kind", "")
+
+	if status != "" {
+		v.Check(validator.In(data.JobStatuses, status), "status", "invalid job status")
+	}
+	if data.ValidatePaginate(p, v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	list, metadata, err := app.models.Jobs.GetAll(p, status, kind)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{
+		"jobs":     list,
+		"metadata": metadata,
+	}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) getJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.parseIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	job, err := app.models.Jobs.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	e := envelope{"job": job}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// retryJobHandler() runs a dead job again.
+func (app *application) retryJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.parseIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	job, err := app.models.Jobs.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.models.Jobs.Retry(job); err != nil {
+		switch {
+		case errors.Is(err, data.ErrJobNotDead):
+			app.jobNotDeadResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	e := envelope{"job": job}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
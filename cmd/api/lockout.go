@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kubil6y/dukkan-go/internal/data"
+)
+
+func (app *application) lockoutPolicy() data.LockoutPolicy {
+	return data.LockoutPolicy{
+		MaxAttempts: app.config.login.maxAttempts,
+		Lockout:     app.config.login.lockout,
+		MaxLockout:  app.config.login.maxLockout,
+		Window:      app.config.login.window,
+	}
+}
+
+// checkCredentials() returns the user when the email and password match,
+// otherwise it writes the error response and records the failed attempt.
+// Unknown emails and wrong passwords get the same response.
+func (app *application) checkCredentials(w http.ResponseWriter, r *http.Request, email, password string) (*data.User, bool) {
+	attempt, err := app.models.LoginAttempts.Get(email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if attempt.IsLocked() {
+		app.loginLockedResponse(w, r, *attempt.LockedUntil)
+		return nil, false
+	}
+
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			data.CompareDummyPassword(password)
+			app.failedLoginResponse(w, r, email, app.invalidCredentialsResponse)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	matches, err := user.ComparePassword(password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if !matches {
+		app.failedLoginResponse(w, r, email, app.invalidCredentialsResponse)
+		return nil, false
+	}
+
+	// hashes made with an older algorithm or cost are upgraded transparently,
+	// a failed upgrade does not fail the login.
+	if user.PasswordNeedsRehash() {
+		if err := user.SetPassword(password); err != nil {
+			app.logError(r, err)
+		} else if err := app.models.Users.UpdatePassword(user); err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	if attempt.Failures > 0 {
+		if err := app.models.LoginAttempts.Reset(email); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return nil, false
+		}
+	}
+	return user, true
+}
+
+// failedLoginResponse() counts the failed attempt before writing the response,
+// lockouts are written to the audit log.
+func (app *application) failedLoginResponse(w http.ResponseWriter, r *http.Request, email string, response http.HandlerFunc) {
+	attempt, locked, err := app.models.LoginAttempts.Fail(email, app.lockoutPolicy())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if locked {
+		app.logger.Warnw("login locked", "email", attempt.Email, "ip", app.clientIP(r), "failures", attempt.Failures)
+		event := &data.AuditEvent{
+			Action:  data.AuditLoginLocked,
+			Email:   attempt.Email,
+			IP:      app.clientIP(r),
+			Details: fmt.Sprintf("locked until %s after %d failed attempts", attempt.LockedUntil.UTC().Format("2006-01-02 15:04:05"), attempt.Failures),
+		}
+		if err := app.models.Audit.Record(event); err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	response(w, r)
+}
+
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.parseIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.Users.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.models.LoginAttempts.Reset(user.Email); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	admin := app.getUserContext(r)
+	event := &data.AuditEvent{
+		Action: data.AuditLoginUnlocked,
+		Email:  user.Email,
+		IP:     app.clientIP(r),
+	}
+	if admin.IsService() {
+		event.Details = "unlocked with API key " + admin.APIKey.Prefix
+	} else {
+		event.ActorID = &admin.ID
+	}
+	if err := app.models.Audit.Record(event); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{"message": "success"}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
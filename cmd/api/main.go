@@ -1,22 +1,31 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/kubil6y/dukkan-go/internal/data"
+	"github.com/kubil6y/dukkan-go/internal/email"
+	"github.com/kubil6y/dukkan-go/internal/jobs"
+	"github.com/kubil6y/dukkan-go/internal/oidc"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gorm.io/gorm"
 )
 
 const version = "1.0.0"
 
 type config struct {
-	port   string
-	env    string
-	domain string
-	db     struct {
-		dsn string
+	port            string
+	env             string
+	domain          string
+	shutdownTimeout time.Duration
+	db              struct {
+		dsn         string
+		schemaCheck bool
 	}
 	limiter struct {
 		enabled bool
@@ -26,14 +35,59 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	idempotency struct {
+		ttl time.Duration
+	}
+	cursor struct {
+		secret string
+	}
+	tokens struct {
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+	login struct {
+		maxAttempts int
+		lockout     time.Duration
+		maxLockout  time.Duration
+		window      time.Duration
+	}
+	oidc struct {
+		providers []oidc.Config
+	}
+	twoFactor struct {
+		issuer       string
+		requireAdmin bool
+	}
+	passwords data.PasswordHashing
+	jobs      struct {
+		workers       int
+		deadRetention time.Duration
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+		dir      string
+		logBody  bool
+	}
 }
 
 type application struct {
-	config  config
-	logger  *zap.SugaredLogger
-	models  data.Models
-	version string
-	wg      sync.WaitGroup
+	config    config
+	logger    *zap.SugaredLogger
+	models    data.Models
+	mailer    *email.Sender
+	providers map[string]oidc.Provider
+	version   string
+	db        *gorm.DB
+	worker    *jobs.Worker
+
+	// shutdown is closed once the server stopped taking requests,
+	// background goroutines in wg return then.
+	shutdown chan struct{}
+	wg       sync.WaitGroup
 }
 
 func main() {
@@ -45,22 +99,28 @@ func main() {
 	logger, _ := loggerConfig.Build()
 	sugar := logger.Sugar()
 
-	db, err := connectDatabase(cfg)
-	if err != nil {
-		sugar.Fatal(err)
+	command, args := "serve", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
 	}
-	autoMigrate(db)
 
-	app := &application{
-		config:  cfg,
-		logger:  sugar,
-		version: version,
-		models:  data.NewModels(db),
+	var err error
+	switch command {
+	case "serve":
+		err = serveCommand(cfg, sugar)
+	case "seed":
+		err = seedCommand(cfg, sugar, args)
+	case "migrate":
+		err = migrateCommand(cfg, args)
+	case "create-admin":
+		err = createAdminCommand(cfg, sugar, args)
+	case "reset-password":
+		err = resetPasswordCommand(cfg, sugar, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", command, commandsUsage)
+		os.Exit(2)
 	}
-
-	// // !!!DANGER!!! app.seed(db) // //
-
-	if err := app.serve(); err != nil {
-		app.logger.Fatalf("failed to start %s server", app.config.env)
+	if err != nil {
+		sugar.Fatal(err)
 	}
 }
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"strings"
@@ -15,6 +19,12 @@ import (
 
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// other systems send an API key instead of a token, see authenticateAPIKey().
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			app.authenticateAPIKey(next, w, r, key)
+			return
+		}
+
 		authHeader := r.Header.Get("Authorization")
 
 		if authHeader == "" {
@@ -38,18 +48,50 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, session, err := app.models.Users.GetSessionForToken(data.ScopeAuthentication, token)
 		if err != nil {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
+		if err := app.models.Tokens.Touch(session); err != nil {
+			app.logError(r, err)
+		}
+
 		r = app.setUserContext(r, user)
+		r = app.setTokenContext(r, session)
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// authenticateAPIKey() puts the service principal of the key into the context,
+// it can only pass requirePermission() for the permissions of the key.
+func (app *application) authenticateAPIKey(next http.Handler, w http.ResponseWriter, r *http.Request, key string) {
+	if !data.IsAPIKey(key) {
+		app.invalidAPIKeyResponse(w, r)
+		return
+	}
+
+	apiKey, err := app.models.APIKeys.GetForKey(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.models.APIKeys.Touch(apiKey); err != nil {
+		app.logError(r, err)
+	}
+
+	r = app.setUserContext(r, apiKey.Principal())
+	next.ServeHTTP(w, r)
+}
+
 func (app *application) requireAuthentication(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.getUserContext(r)
@@ -57,6 +99,11 @@ func (app *application) requireAuthentication(next http.HandlerFunc) http.Handle
 			app.authenticationRequiredResponse(w, r)
 			return
 		}
+		// API keys do not belong to an account, they can't use account routes.
+		if user.IsService() {
+			app.notPermittedResponse(w, r)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -73,17 +120,151 @@ func (app *application) requireActivation(next http.HandlerFunc) http.HandlerFun
 	return app.requireAuthentication(fn)
 }
 
-func (app *application) requireRole(roleName string, next http.HandlerFunc) http.HandlerFunc {
+// requirePermission() lets the request through when the role of the user
+// was granted the permission, e.g. requirePermission("orders:write", ...)
+// Users of a role which requires two factor authentication need it enabled.
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.getUserContext(r)
-		if user.Role.Name != roleName {
+		if !user.Role.HasPermission(code) {
 			app.notPermittedResponse(w, r)
 			return
 		}
+		if app.roleRequiresTwoFactor(user.Role) && !user.IsService() && !user.TwoFactorEnabled {
+			app.twoFactorRequiredResponse(w, r)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 
-	return app.requireActivation(fn)
+	userFn := app.requireActivation(fn)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.getUserContext(r).IsService() {
+			fn.ServeHTTP(w, r)
+			return
+		}
+		userFn.ServeHTTP(w, r)
+	})
+}
+
+// roleRequiresTwoFactor() reports whether users of the role need two factor
+// authentication, -2fa-require-admin requires it for the admin role.
+func (app *application) roleRequiresTwoFactor(role *data.Role) bool {
+	if role == nil {
+		return false
+	}
+	return role.RequireTwoFactor || (app.config.twoFactor.requireAdmin && role.Name == "admin")
+}
+
+// responseRecorder keeps a copy of the response, so it can be stored by idempotent().
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent() makes POST handlers safe to retry with an Idempotency-Key header,
+// the first response is stored and replayed for repeats of the same request.
+// It must run after authentication, keys are scoped to the user.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > 255 {
+			app.badRequestResponse(w, r, errors.New("idempotency key must not be more than 255 bytes long"))
+			return
+		}
+
+		maxBytes := 1_048_576
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+		if err != nil {
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytes))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		hash.Write(body)
+		requestHash := hash.Sum(nil)
+
+		user := app.getUserContext(r)
+
+		stored, err := app.models.IdempotencyKeys.Get(user.ID, key)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if stored != nil {
+			switch {
+			case !bytes.Equal(stored.RequestHash, requestHash):
+				app.idempotencyKeyMismatchResponse(w, r)
+			case !stored.IsCompleted():
+				app.idempotencyKeyInProgressResponse(w, r)
+			default:
+				w.Header().Set("Content-Type", stored.ContentType)
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.StatusCode)
+				w.Write(stored.ResponseBody)
+			}
+			return
+		}
+
+		idempotencyKey := &data.IdempotencyKey{
+			Key:         key,
+			UserID:      user.ID,
+			RequestHash: requestHash,
+			Expiry:      time.Now().Add(app.config.idempotency.ttl),
+		}
+		if err := app.models.IdempotencyKeys.Insert(idempotencyKey); err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateRecord):
+				app.idempotencyKeyInProgressResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+
+		// server errors are not stored, the client should be able to retry them.
+		defer func() {
+			if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+				if err := app.models.IdempotencyKeys.Delete(idempotencyKey); err != nil {
+					app.logError(r, err)
+				}
+				return
+			}
+
+			idempotencyKey.StatusCode = rec.status
+			idempotencyKey.ContentType = rec.Header().Get("Content-Type")
+			idempotencyKey.ResponseBody = rec.body.Bytes()
+			if err := app.models.IdempotencyKeys.Complete(idempotencyKey); err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
 }
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -113,10 +294,20 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 	)
 
 	// Launch a background goroutine which removes old entries from the clients map
-	// once every minute.
+	// once every minute, it stops when the server shuts down.
+	app.wg.Add(1)
 	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
 		for {
-			time.Sleep(time.Minute)
+			select {
+			case <-app.shutdown:
+				return
+			case <-ticker.C:
+			}
 
 			// Lock the mutex to prevent any rate limiter checks from happening
 			// while the cleanup is taking place.
@@ -195,7 +386,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 						// Set the necessary preflight response headers, as discussed
 						// previously.
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Idempotency-Key, X-API-Key")
 
 						// Write the headers along with a 200 OK status and return from
 						// the middleware with no further action.
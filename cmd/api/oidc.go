@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kubil6y/dukkan-go/internal/data"
+	"github.com/kubil6y/dukkan-go/internal/oidc"
+	"github.com/kubil6y/dukkan-go/internal/validator"
+)
+
+// startOIDCLoginHandler() returns the url of the provider's login page,
+// the provider redirects the user back to the client with a code and state.
+func (app *application) startOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.providers[app.parseProviderParam(r)]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	state, err := oidc.RandomString(32)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	nonce, err := oidc.RandomString(32)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	verifier, err := oidc.RandomString(32)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.models.Identities.NewState(provider.Name(), state, nonce, verifier, 10*time.Minute); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	u, err := provider.AuthCodeURL(state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{"authorization_url": u}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// oidcCallbackHandler() finishes the login with the code and state the client
+// got from the provider, the user is created when needed. When the request is
+// authenticated, the identity is linked to the logged in user instead.
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.providers[app.parseProviderParam(r)]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input oidcCallbackDTO
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	state, err := app.models.Identities.ConsumeState(provider.Name(), input.State)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired state")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), input.Code, state.Nonce, state.CodeVerifier)
+	if err != nil {
+		app.logError(r, err)
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	// a logged in user links the identity to their account, existing accounts
+	// are never linked by their email alone.
+	if current := app.getUserContext(r); !current.IsAnon() && !current.IsService() {
+		app.linkIdentity(w, r, current, provider.Name(), identity)
+		return
+	}
+
+	role, err := app.models.Roles.GetByName("user")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.Identities.GetOrCreateUser(provider.Name(), identity, role.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnverifiedEmail):
+			app.unverifiedEmailResponse(w, r)
+		case errors.Is(err, data.ErrIdentityLinkRequired):
+			app.identityLinkRequiredResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		app.writeTwoFactorChallenge(w, r, user)
+		return
+	}
+
+	token, refresh, err := app.models.Tokens.NewSession(user.ID, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, r.UserAgent(), app.clientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{
+		"user": user,
+		"authentication_token": map[string]interface{}{
+			"token":  token.Plaintext,
+			"expiry": token.Expiry,
+		},
+		"refresh_token": map[string]interface{}{
+			"token":  refresh.Plaintext,
+			"expiry": refresh.Expiry,
+		}}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) linkIdentity(w http.ResponseWriter, r *http.Request, user *data.User, provider string, identity *oidc.Identity) {
+	if err := app.models.Identities.Link(user, provider, identity); err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRecord):
+			app.identityLinkedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.getIdentitiesHandler(w, r)
+}
+
+func (app *application) getIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserContext(r)
+
+	identities, err := app.models.Identities.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{"identities": identities}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -71,6 +71,11 @@ func (app *application) getAllOrdersHandler(w http.ResponseWriter, r *http.Reque
 	v := validator.New()
 	p := data.NewPaginate(r, v, 10, 1)
 
+	if data.ValidatePaginate(p, v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	orders, metadata, err := app.models.Orders.GetAllOrders(p)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -125,6 +130,11 @@ func (app *application) getOrdersByUserHandler(w http.ResponseWriter, r *http.Re
 	v := validator.New()
 	p := data.NewPaginate(r, v, 10, 1)
 
+	if data.ValidatePaginate(p, v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	orders, metadata, err := app.models.Orders.GetAllOrdersByUserID(p, id)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -163,6 +173,10 @@ func (app *application) createOrderHandler(w http.ResponseWriter, r *http.Reques
 		switch {
 		case errors.Is(err, data.ErrOutOfStock):
 			app.outOfStockResponse(w, r)
+		case errors.Is(err, data.ErrCurrencyMismatch):
+			app.badRequestResponse(w, r, errors.New("order items must have the same currency"))
+		case errors.Is(err, data.ErrVariantRequired), errors.Is(err, data.ErrInvalidVariant):
+			app.badRequestResponse(w, r, err)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -239,8 +253,14 @@ func (app *application) deleteOrderHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// stock which the order still holds is given back.
 	if err := app.models.Orders.Delete(order); err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -251,3 +271,189 @@ func (app *application) deleteOrderHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 }
+
+func (app *application) getOrderTransitionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.parseIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	order, err := app.models.Orders.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	transitions, err := app.models.Orders.GetTransitions(order.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{
+		"status":      order.Status,
+		"transitions": transitions,
+	}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) createOrderTransitionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.parseIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var input orderTransitionDTO
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	order, err := app.models.Orders.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.getUserContext(r)
+	from := order.Status
+	to := sanitize(input.Status)
+
+	if err := app.models.Orders.Transition(order, to, user.ID, input.Note); err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidTransition):
+			app.invalidTransitionResponse(w, r, from, to)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	e := envelope{"order": order}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) cancelOrderOfAuthUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.parseIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var input cancelOrderDTO
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	order, err := app.models.Orders.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.getUserContext(r)
+	if order.UserID != user.ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.cancelOrder(w, r, order, user.ID, input.Reason)
+}
+
+func (app *application) cancelOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.parseIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var input cancelOrderDTO
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	order, err := app.models.Orders.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.getUserContext(r)
+	app.cancelOrder(w, r, order, user.ID, input.Reason)
+}
+
+// cancelOrder() is shared by the customer and admin cancel handlers.
+func (app *application) cancelOrder(w http.ResponseWriter, r *http.Request, order *data.Order, userID int64, reason string) {
+	from := order.Status
+
+	if err := app.models.Orders.Cancel(order, userID, reason); err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidTransition):
+			app.invalidTransitionResponse(w, r, from, data.OrderStatusCancelled)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	e := envelope{"order": order}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
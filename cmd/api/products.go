@@ -3,7 +3,6 @@ package main
 import (
 	"errors"
 	"net/http"
-	"strings"
 
 	"github.com/kubil6y/dukkan-go/internal/data"
 	"github.com/kubil6y/dukkan-go/internal/validator"
@@ -55,18 +54,41 @@ func (app *application) createProductHandler(w http.ResponseWriter, r *http.Requ
 
 func (app *application) getAllProductsHandler(w http.ResponseWriter, r *http.Request) {
 	qs := r.URL.Query()
-	searchTerm := app.readString(qs, "search", "")
-	searchTerm = strings.ToLower(strings.Trim(searchTerm, " "))
-
 	v := validator.New()
+
+	var f data.ProductFilters
+	f.Search = sanitize(app.readString(qs, "search", ""))
+	f.Highlight = app.readBool(qs, v, "highlight", false)
+	f.Category = sanitize(app.readString(qs, "category", ""))
+	for _, brand := range app.readCSV(qs, "brand", nil) {
+		if brand = sanitize(brand); brand != "" {
+			f.Brands = append(f.Brands, brand)
+		}
+	}
+	f.MinPrice = app.readInt64Ptr(qs, v, "min_price")
+	f.MaxPrice = app.readInt64Ptr(qs, v, "max_price")
+	f.InStock = app.readBool(qs, v, "in_stock", false)
+	f.MinRating = app.readFloat(qs, v, "min_rating", 0)
+	f.Sort = app.readCSV(qs, "sort", nil)
+
 	p := data.NewPaginate(r, v, 10, 1)
 
+	data.ValidateProductFilters(&f, v)
+	if p.IsCursor() {
+		v.Check(!f.IsOrdered(), "after", "cursors can not be used together with search or sort")
+	}
 	if data.ValidatePaginate(p, v); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	products, metadata, err := app.models.Products.GetAll(p, searchTerm)
+	products, metadata, err := app.models.Products.GetAll(p, f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	facets, err := app.models.Products.Facets(f)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -74,6 +96,7 @@ func (app *application) getAllProductsHandler(w http.ResponseWriter, r *http.Req
 
 	e := envelope{
 		"products": products,
+		"facets":   facets,
 		"metadata": metadata,
 	}
 	out := app.outOK(e)
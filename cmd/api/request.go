@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"strings"
 	"time"
 
@@ -62,8 +64,93 @@ func (d *createAuthenticationTokenDTO) validate(v *validator.Validator) {
 	v.Check(govalidator.IsEmail(d.Email), "email", "must be a valid email address")
 }
 
+type refreshTokenDTO struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (d *refreshTokenDTO) validate(v *validator.Validator) {
+	v.Check(d.RefreshToken != "", "refresh_token", "must be provided")
+	v.Check(len(d.RefreshToken) == 26, "refresh_token", "must be 26 bytes long")
+}
+
+type twoFactorDTO struct {
+	Code string `json:"code"`
+}
+
+func (d *twoFactorDTO) validate(v *validator.Validator) {
+	v.Check(d.Code != "", "code", "must be provided")
+	v.Check(len(d.Code) == 6, "code", "must be 6 digits long")
+}
+
+type disableTwoFactorDTO struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+func (d *disableTwoFactorDTO) validate(v *validator.Validator) {
+	v.Check(d.Password != "", "password", "must be provided")
+	v.Check(d.Code != "", "code", "must be provided")
+	v.Check(len(d.Code) == 6, "code", "must be 6 digits long")
+}
+
+type verifyTwoFactorDTO struct {
+	TwoFactorToken string `json:"two_factor_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+func (d *verifyTwoFactorDTO) validate(v *validator.Validator) {
+	v.Check(d.TwoFactorToken != "", "two_factor_token", "must be provided")
+	v.Check(len(d.TwoFactorToken) == 26, "two_factor_token", "must be 26 bytes long")
+	v.Check(d.Code != "" || d.RecoveryCode != "", "code", "code or recovery_code must be provided")
+	v.Check(d.Code == "" || d.RecoveryCode == "", "code", "only one of code and recovery_code can be provided")
+	if d.Code != "" {
+		v.Check(len(d.Code) == 6, "code", "must be 6 digits long")
+	}
+}
+
+type grantPermissionDTO struct {
+	Permission string `json:"permission"`
+}
+
+func (d *grantPermissionDTO) validate(v *validator.Validator) {
+	v.Check(d.Permission != "", "permission", "must be provided")
+}
+
+type createAPIKeyDTO struct {
+	Name        string     `json:"name"`
+	Permissions []string   `json:"permissions"`
+	Expiry      *time.Time `json:"expiry"`
+}
+
+func (d *createAPIKeyDTO) validate(v *validator.Validator) {
+	v.Check(d.Name != "", "name", "must be provided")
+	v.Check(len(d.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(d.Permissions) > 0, "permissions", "must contain at least one permission")
+	seen := make(map[string]bool)
+	for _, code := range d.Permissions {
+		v.Check(validator.In(data.PermissionCodes, code), "permissions", fmt.Sprintf("%q is not a permission", code))
+		v.Check(!seen[code], "permissions", "must not contain duplicate values")
+		seen[code] = true
+	}
+	if d.Expiry != nil {
+		v.Check(d.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+type oidcCallbackDTO struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+func (d *oidcCallbackDTO) validate(v *validator.Validator) {
+	v.Check(d.Code != "", "code", "must be provided")
+	v.Check(d.State != "", "state", "must be provided")
+}
+
 type createRoleDTO struct {
-	Name string `json:"name"`
+	Name             string `json:"name"`
+	RequireTwoFactor bool   `json:"require_two_factor"`
 }
 
 func (d *createRoleDTO) validate(v *validator.Validator) {
@@ -73,13 +160,15 @@ func (d *createRoleDTO) validate(v *validator.Validator) {
 
 func (d *createRoleDTO) populate(role *data.Role) {
 	role.Name = sanitize(d.Name)
+	role.RequireTwoFactor = d.RequireTwoFactor
 }
 
 // seems like duplication but in the future,
 // role fields might change, and there might be
 // fields that we dont allow them to change.
 type updateRoleDTO struct {
-	Name string `json:"name"`
+	Name             string `json:"name"`
+	RequireTwoFactor *bool  `json:"require_two_factor"`
 }
 
 func (d *updateRoleDTO) validate(v *validator.Validator) {
@@ -89,6 +178,9 @@ func (d *updateRoleDTO) validate(v *validator.Validator) {
 
 func (d *updateRoleDTO) populate(role *data.Role) {
 	role.Name = sanitize(d.Name)
+	if d.RequireTwoFactor != nil {
+		role.RequireTwoFactor = *d.RequireTwoFactor
+	}
 }
 
 type updateUserRoleDTO struct {
@@ -123,10 +215,44 @@ func (d *generateActivationTokenDTO) validate(v *validator.Validator) {
 	v.Check(govalidator.IsEmail(d.Email), "email", "must be a valid email address")
 }
 
+type passwordResetTokenDTO struct {
+	Email string `json:"email"`
+}
+
+func (d *passwordResetTokenDTO) validate(v *validator.Validator) {
+	v.Check(d.Email != "", "email", "must be provided")
+	v.Check(govalidator.IsEmail(d.Email), "email", "must be a valid email address")
+}
+
+type resetPasswordDTO struct {
+	Code            string `json:"code"`
+	Password        string `json:"password"`
+	PasswordConfirm string `json:"password_confirm"`
+}
+
+func (d *resetPasswordDTO) validate(v *validator.Validator) {
+	v.Check(d.Code != "", "code", "must be provided")
+	v.Check(len(d.Code) == 26, "code", "must be 26 bytes long")
+	v.Check(d.Password != "", "password", "must be provided")
+	v.Check(d.PasswordConfirm != "", "password_confirm", "must be provided")
+	v.Check(len(d.Password) >= 6, "password", "must be at least six characters")
+	v.Check(d.Password == d.PasswordConfirm, "password", "passwords do not match")
+}
+
+type confirmEmailChangeDTO struct {
+	Code string `json:"code"`
+}
+
+func (d *confirmEmailChangeDTO) validate(v *validator.Validator) {
+	v.Check(d.Code != "", "code", "must be provided")
+	v.Check(len(d.Code) == 26, "code", "must be 26 bytes long")
+}
+
 type editProfileDTO struct {
 	FirstName       *string `json:"first_name"`
 	LastName        *string `json:"last_name"`
 	Email           *string `json:"email"`
+	CurrentPassword *string `json:"current_password"`
 	Password        *string `json:"password"`
 	PasswordConfirm *string `json:"password_confirm"`
 	Address         *string `json:"address"`
@@ -143,9 +269,12 @@ func (d *editProfileDTO) validate(v *validator.Validator) {
 		v.Check(len(*d.LastName) > 2, "last_name", "must be longer then two characters")
 	}
 
+	// the email is only changed after the new address is confirmed,
+	// see editProfileHandler()
 	if d.Email != nil {
-		v.Check(*d.FirstName != "", "email", "must be provided")
+		v.Check(*d.Email != "", "email", "must be provided")
 		v.Check(govalidator.IsEmail(*d.Email), "email", "must be a valid email address")
+		v.Check(d.CurrentPassword != nil && *d.CurrentPassword != "", "current_password", "must be provided to change the email")
 	}
 
 	if d.PasswordConfirm != nil {
@@ -164,6 +293,7 @@ func (d *editProfileDTO) validate(v *validator.Validator) {
 		v.Check(*d.PasswordConfirm != "", "password_confirm", "must be provided")
 		v.Check(len(*d.Password) >= 6, "password", "must be at least six characters")
 		v.Check(*d.Password == *d.PasswordConfirm, "password", "passwords do not match")
+		v.Check(d.CurrentPassword != nil && *d.CurrentPassword != "", "current_password", "must be provided to change the password")
 	}
 
 	if d.Address != nil {
@@ -172,7 +302,7 @@ func (d *editProfileDTO) validate(v *validator.Validator) {
 	}
 }
 
-func (d *editProfileDTO) populate(user *data.User) {
+func (d *editProfileDTO) populate(user *data.User) error {
 	if d.FirstName != nil {
 		user.FirstName = sanitize(*d.FirstName)
 	}
@@ -180,27 +310,27 @@ func (d *editProfileDTO) populate(user *data.User) {
 	if d.LastName != nil {
 		user.LastName = sanitize(*d.LastName)
 	}
-	if d.Email != nil {
-		user.Email = sanitize(*d.Email)
-	}
-
 	if d.Password != nil {
-		user.SetPassword(*d.Password)
+		if err := user.SetPassword(*d.Password); err != nil {
+			return err
+		}
 	}
 
 	if d.Address != nil {
 		user.Address = sanitize(*d.Address)
 	}
+	return nil
 }
 
 type createProductDTO struct {
-	Name         string  `json:"name"`
-	Description  string  `json:"description"`
-	Brand        string  `json:"brand"`
-	CategoryName string  `json:"category_name"`
-	Image        string  `json:"image"`
-	Price        float64 `json:"price"`
-	Count        int64   `json:"count"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Brand        string `json:"brand"`
+	CategoryName string `json:"category_name"`
+	Image        string `json:"image"`
+	Price        int64  `json:"price"`
+	Currency     string `json:"currency"`
+	Count        int64  `json:"count"`
 }
 
 func (d *createProductDTO) validate(v *validator.Validator) {
@@ -215,6 +345,9 @@ func (d *createProductDTO) validate(v *validator.Validator) {
 	v.Check(govalidator.IsURL(d.Image), "image", "must be valid URL")
 	v.Check(d.Price >= 0, "price", "must be valid value")
 	v.Check(d.Count >= 0, "count", "must be valid value")
+	if d.Currency != "" {
+		validateCurrency(v, d.Currency)
+	}
 }
 
 func (d *createProductDTO) populate(product *data.Product) {
@@ -223,18 +356,22 @@ func (d *createProductDTO) populate(product *data.Product) {
 	product.Description = sanitize(d.Description)
 	product.Brand = sanitize(d.Brand)
 	product.Image = sanitize(d.Image)
-	product.Price = d.Price
+	product.Price = data.NewMoney(d.Price, data.DefaultCurrency)
+	if d.Currency != "" {
+		product.Price.Currency = strings.ToUpper(d.Currency)
+	}
 	product.Count = d.Count
 }
 
 type updateProductDTO struct {
-	Name         *string  `json:"name"`
-	Description  *string  `json:"description"`
-	Brand        *string  `json:"brand"`
-	CategoryName *string  `json:"category_name"`
-	Image        *string  `json:"image"`
-	Price        *float64 `json:"price"`
-	Count        *int64   `json:"count"`
+	Name         *string `json:"name"`
+	Description  *string `json:"description"`
+	Brand        *string `json:"brand"`
+	CategoryName *string `json:"category_name"`
+	Image        *string `json:"image"`
+	Price        *int64  `json:"price"`
+	Currency     *string `json:"currency"`
+	Count        *int64  `json:"count"`
 }
 
 func (d *updateProductDTO) validate(v *validator.Validator) {
@@ -244,6 +381,9 @@ func (d *updateProductDTO) validate(v *validator.Validator) {
 	if d.Price != nil {
 		v.Check(*d.Price >= 0, "price", "must be valid value")
 	}
+	if d.Currency != nil {
+		validateCurrency(v, *d.Currency)
+	}
 	if d.Count != nil {
 		v.Check(*d.Count >= 0, "count", "must be valid value")
 	}
@@ -264,13 +404,117 @@ func (d *updateProductDTO) populate(product *data.Product) {
 		product.Image = *d.Image
 	}
 	if d.Price != nil {
-		product.Price = *d.Price
+		product.Price.Amount = *d.Price
+	}
+	if d.Currency != nil {
+		product.Price.Currency = strings.ToUpper(*d.Currency)
 	}
 	if d.Count != nil {
 		product.Count = *d.Count
 	}
 }
 
+// validateCurrency() checks for a three letter ISO 4217 code
+func validateCurrency(v *validator.Validator, currency string) {
+	v.Check(len(currency) == 3 && govalidator.IsAlpha(currency), "currency", "must be a three letter currency code")
+}
+
+type createVariantDTO struct {
+	SKU           string            `json:"sku"`
+	Options       map[string]string `json:"options"`
+	PriceOverride *int64            `json:"price_override"`
+	Count         int64             `json:"count"`
+}
+
+func (d *createVariantDTO) validate(v *validator.Validator) {
+	v.Check(d.SKU != "", "sku", "must be provided")
+	v.Check(len(d.SKU) <= 64, "sku", "must not be more than 64 characters")
+	v.Check(len(d.Options) > 0, "options", "must be provided")
+	for name, value := range d.Options {
+		v.Check(name != "" && value != "", "options", "must not contain empty names or values")
+	}
+	if d.PriceOverride != nil {
+		v.Check(*d.PriceOverride >= 0, "price_override", "must be valid value")
+	}
+	v.Check(d.Count >= 0, "count", "must be valid value")
+}
+
+func (d *createVariantDTO) populate(variant *data.ProductVariant) {
+	variant.SKU = strings.ToUpper(strings.Trim(d.SKU, " "))
+	variant.Options = data.VariantOptions{}
+	for name, value := range d.Options {
+		variant.Options[sanitize(name)] = sanitize(value)
+	}
+	variant.PriceOverride = d.PriceOverride
+	variant.Count = d.Count
+}
+
+// nullableInt64 tells a missing field from an explicit null: Set is true
+// when the field was sent, Value is nil when it was null.
+type nullableInt64 struct {
+	Set   bool
+	Value *int64
+}
+
+func (n *nullableInt64) UnmarshalJSON(b []byte) error {
+	n.Set = true
+	n.Value = nil
+	if string(b) == "null" {
+		return nil
+	}
+	var value int64
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	n.Value = &value
+	return nil
+}
+
+// price_override: null clears the override, the variant uses the product price again.
+type updateVariantDTO struct {
+	SKU           *string            `json:"sku"`
+	Options       *map[string]string `json:"options"`
+	PriceOverride nullableInt64      `json:"price_override"`
+	Count         *int64             `json:"count"`
+}
+
+func (d *updateVariantDTO) validate(v *validator.Validator) {
+	if d.SKU != nil {
+		v.Check(*d.SKU != "", "sku", "must be provided")
+		v.Check(len(*d.SKU) <= 64, "sku", "must not be more than 64 characters")
+	}
+	if d.Options != nil {
+		v.Check(len(*d.Options) > 0, "options", "must be provided")
+		for name, value := range *d.Options {
+			v.Check(name != "" && value != "", "options", "must not contain empty names or values")
+		}
+	}
+	if d.PriceOverride.Value != nil {
+		v.Check(*d.PriceOverride.Value >= 0, "price_override", "must be valid value")
+	}
+	if d.Count != nil {
+		v.Check(*d.Count >= 0, "count", "must be valid value")
+	}
+}
+
+func (d *updateVariantDTO) populate(variant *data.ProductVariant) {
+	if d.SKU != nil {
+		variant.SKU = strings.ToUpper(strings.Trim(*d.SKU, " "))
+	}
+	if d.Options != nil {
+		variant.Options = data.VariantOptions{}
+		for name, value := range *d.Options {
+			variant.Options[sanitize(name)] = sanitize(value)
+		}
+	}
+	if d.PriceOverride.Set {
+		variant.PriceOverride = d.PriceOverride.Value
+	}
+	if d.Count != nil {
+		variant.Count = *d.Count
+	}
+}
+
 type reviewDTO struct {
 	Text string `json:"text"`
 }
@@ -305,8 +549,6 @@ func (d categoryDTO) populate(category *data.Category) {
 
 type editOrderDTO struct {
 	PaymentMethod *string `json:"payment_method"`
-	IsPaid        *bool   `json:"is_paid"`
-	IsDelivered   *bool   `json:"is_delivered"`
 }
 
 func (d *editOrderDTO) validate(v *validator.Validator) {
@@ -319,18 +561,56 @@ func (d *editOrderDTO) populate(order *data.Order) {
 	if d.PaymentMethod != nil {
 		order.PaymentMethod = *d.PaymentMethod
 	}
+}
 
-	if d.IsPaid != nil {
-		if *d.IsPaid == true {
-			order.IsPaid = true
-			order.PaidAt = time.Now()
-		}
-	}
+type orderTransitionDTO struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
 
-	if d.IsDelivered != nil {
-		if *d.IsDelivered == true {
-			order.IsDelivered = true
-			order.DeliveredAt = time.Now()
-		}
-	}
+func (d *orderTransitionDTO) validate(v *validator.Validator) {
+	v.Check(d.Status != "", "status", "must be provided")
+	v.Check(data.In(data.OrderStatuses, sanitize(d.Status)), "status", "must be a valid order status")
+	v.Check(len(d.Note) <= 500, "note", "must not be more than 500 characters")
+}
+
+type cancelOrderDTO struct {
+	Reason string `json:"reason"`
+}
+
+func (d *cancelOrderDTO) validate(v *validator.Validator) {
+	v.Check(d.Reason != "", "reason", "must be provided")
+	v.Check(len(d.Reason) <= 500, "reason", "must not be more than 500 characters")
+}
+
+type addCartItemDTO struct {
+	ProductID int64 `json:"product_id"`
+	VariantID int64 `json:"variant_id"`
+	Quantity  int64 `json:"quantity"`
+}
+
+func (d *addCartItemDTO) validate(v *validator.Validator) {
+	v.Check(d.ProductID != 0, "product_id", "must be provided")
+	v.Check(d.ProductID > 0, "product_id", "invalid product id value")
+	v.Check(d.VariantID >= 0, "variant_id", "invalid variant id value")
+	v.Check(d.Quantity > 0, "quantity", "must be greater than zero")
+	v.Check(d.Quantity <= 100, "quantity", "must be a maximum of 100")
+}
+
+type updateCartItemDTO struct {
+	Quantity int64 `json:"quantity"`
+}
+
+func (d *updateCartItemDTO) validate(v *validator.Validator) {
+	v.Check(d.Quantity > 0, "quantity", "must be greater than zero")
+	v.Check(d.Quantity <= 100, "quantity", "must be a maximum of 100")
+}
+
+type checkoutDTO struct {
+	PaymentMethod string `json:"payment_method"`
+}
+
+func (d *checkoutDTO) validate(v *validator.Validator) {
+	v.Check(d.PaymentMethod != "", "payment_method", "must be provided")
+	v.Check(data.In([]string{"cash", "credit"}, sanitize(d.PaymentMethod)), "payment_method", "must be cash or credit")
 }
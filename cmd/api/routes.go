@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/kubil6y/dukkan-go/internal/data"
 )
 
 func (app *application) routes() http.Handler {
@@ -14,12 +15,28 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodPost, "/v1/register", app.registerHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthentication(app.deleteAuthenticationTokenHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/login", app.loginHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/two-factor", app.verifyTwoFactorHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oauth/:provider", app.startOIDCLoginHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/:provider/callback", app.oidcCallbackHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.activateAccountHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/generate-activation", app.generateActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.resetPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
 
 	router.HandlerFunc(http.MethodGet, "/v1/profile", app.requireAuthentication(app.getProfileHandler))
 	router.HandlerFunc(http.MethodPatch, "/v1/profile/edit", app.requireAuthentication(app.editProfileHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/profile/sessions", app.requireAuthentication(app.getSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/profile/sessions", app.requireAuthentication(app.deleteAllSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/profile/sessions/:id", app.requireAuthentication(app.deleteSessionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/profile/identities", app.requireAuthentication(app.getIdentitiesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/profile/two-factor", app.requireAuthentication(app.enrollTwoFactorHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/profile/two-factor/confirm", app.requireAuthentication(app.confirmTwoFactorHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/profile/two-factor/recovery-codes", app.requireAuthentication(app.regenerateRecoveryCodesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/profile/two-factor", app.requireAuthentication(app.disableTwoFactorHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/products/:slug/review", app.requireAuthentication(app.createReviewHandler))
 	router.HandlerFunc(http.MethodPut, "/v1/products/:id/review", app.requireAuthentication(app.updateReviewHandler))
@@ -29,38 +46,65 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodPut, "/v1/products/:id/rating", app.requireAuthentication(app.updateRatingHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/products/:id/rating", app.requireAuthentication(app.deleteRatingHandler))
 
-	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requireRole("admin", app.getAllUsersHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requireRole("admin", app.getUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission(data.PermissionUsersRead, app.getAllUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission(data.PermissionUsersRead, app.getUserHandler))
 
 	router.HandlerFunc(http.MethodGet, "/v1/my-orders", app.requireActivation(app.getOrdersOfAuthUserHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/my-orders/:id", app.requireActivation(app.getOrderByIDOfAuthUserHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/orders", app.requireActivation(app.createOrderHandler))
-
-	router.HandlerFunc(http.MethodGet, "/v1/admin/orders", app.requireRole("admin", app.getAllOrdersHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/admin/orders/:id", app.requireRole("admin", app.getOrderHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/admin/user/:id/orders", app.requireRole("admin", app.getOrdersByUserHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/admin/orders/:id", app.requireRole("admin", app.editOrderHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/admin/orders/:id", app.requireRole("admin", app.deleteOrderHandler))
-
-	router.HandlerFunc(http.MethodPost, "/v1/admin/roles", app.requireRole("admin", app.createRoleHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requireRole("admin", app.getAllRolesHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/admin/roles/:id", app.requireRole("admin", app.getRoleHandler))
-	router.HandlerFunc(http.MethodPut, "/v1/admin/roles/:id", app.requireRole("admin", app.updateRoleHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/admin/roles/:id", app.requireRole("admin", app.deleteRoleHandler))
-	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/role", app.requireRole("admin", app.updateUserRoleHandler))
-
-	router.HandlerFunc(http.MethodPost, "/v1/admin/categories", app.requireRole("admin", app.createCategoryHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/admin/categories", app.requireRole("admin", app.getAllCategoriesHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/admin/categories/:id", app.requireRole("admin", app.getCategoryHandler))
-	router.HandlerFunc(http.MethodPut, "/v1/admin/categories/:id", app.requireRole("admin", app.updateCategoryHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/admin/categories/:id", app.requireRole("admin", app.deleteCategoryHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/my-orders/:id/cancel", app.requireActivation(app.cancelOrderOfAuthUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/orders", app.requireActivation(app.idempotent(app.createOrderHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/cart", app.requireActivation(app.getCartHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/cart", app.requireActivation(app.clearCartHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/cart/items", app.requireActivation(app.addCartItemHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/cart/items/:id", app.requireActivation(app.updateCartItemHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/cart/items/:id", app.requireActivation(app.deleteCartItemHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/cart/checkout", app.requireActivation(app.idempotent(app.checkoutHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/orders", app.requirePermission(data.PermissionOrdersRead, app.getAllOrdersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/orders/:id", app.requirePermission(data.PermissionOrdersRead, app.getOrderHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/user/:id/orders", app.requirePermission(data.PermissionOrdersRead, app.getOrdersByUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/orders/:id", app.requirePermission(data.PermissionOrdersWrite, app.editOrderHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/orders/:id", app.requirePermission(data.PermissionOrdersWrite, app.deleteOrderHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/orders/:id/cancel", app.requirePermission(data.PermissionOrdersWrite, app.cancelOrderHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/orders/:id/transitions", app.requirePermission(data.PermissionOrdersRead, app.getOrderTransitionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/orders/:id/transitions", app.requirePermission(data.PermissionOrdersWrite, app.createOrderTransitionHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/admin/roles", app.requirePermission(data.PermissionRolesWrite, app.createRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission(data.PermissionRolesRead, app.getAllRolesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles/:id", app.requirePermission(data.PermissionRolesRead, app.getRoleHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/roles/:id", app.requirePermission(data.PermissionRolesWrite, app.updateRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/roles/:id", app.requirePermission(data.PermissionRolesWrite, app.deleteRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/permissions", app.requirePermission(data.PermissionRolesRead, app.getAllPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/roles/:id/permissions", app.requirePermission(data.PermissionRolesWrite, app.grantPermissionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/roles/:id/permissions/:code", app.requirePermission(data.PermissionRolesWrite, app.revokePermissionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/unlock", app.requirePermission(data.PermissionUsersWrite, app.unlockUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/audit-events", app.requirePermission(data.PermissionAuditRead, app.getAuditEventsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/role", app.requirePermission(data.PermissionUsersWrite, app.updateUserRoleHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/admin/api-keys", app.requirePermission(data.PermissionAPIKeysWrite, app.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/api-keys", app.requirePermission(data.PermissionAPIKeysRead, app.getAllAPIKeysHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/api-keys/:id", app.requirePermission(data.PermissionAPIKeysWrite, app.deleteAPIKeyHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/jobs", app.requirePermission(data.PermissionJobsRead, app.getAllJobsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/jobs/:id", app.requirePermission(data.PermissionJobsRead, app.getJobHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/jobs/:id/retry", app.requirePermission(data.PermissionJobsWrite, app.retryJobHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/admin/categories", app.requirePermission(data.PermissionCategoriesWrite, app.createCategoryHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/categories", app.requirePermission(data.PermissionCategoriesRead, app.getAllCategoriesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/categories/:id", app.requirePermission(data.PermissionCategoriesRead, app.getCategoryHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/categories/:id", app.requirePermission(data.PermissionCategoriesWrite, app.updateCategoryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/categories/:id", app.requirePermission(data.PermissionCategoriesWrite, app.deleteCategoryHandler))
 
 	router.HandlerFunc(http.MethodGet, "/v1/products", app.getAllProductsHandler)                       // public
 	router.HandlerFunc(http.MethodGet, "/v1/products/:slug", app.getProductHandler)                     // public
 	router.HandlerFunc(http.MethodGet, "/v1/products/:slug/category", app.getProductsByCategoryHandler) // public
-	router.HandlerFunc(http.MethodPost, "/v1/admin/products", app.requireRole("admin", app.createProductHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/admin/products/:id", app.requireRole("admin", app.updateProductHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/admin/products/:id", app.requireRole("admin", app.deleteProductHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/products", app.requirePermission(data.PermissionProductsWrite, app.createProductHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/products/:id", app.requirePermission(data.PermissionProductsWrite, app.updateProductHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/products/:id", app.requirePermission(data.PermissionProductsWrite, app.deleteProductHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/products/:id/variants", app.requirePermission(data.PermissionProductsWrite, app.createVariantHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/variants/:id", app.requirePermission(data.PermissionProductsWrite, app.updateVariantHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/variants/:id", app.requirePermission(data.PermissionProductsWrite, app.deleteVariantHandler))
 
 	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))
 }
@@ -1,97 +1,136 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"math/rand"
-	"time"
 
 	"github.com/bxcodec/faker/v3"
 	"github.com/gosimple/slug"
 	"github.com/kubil6y/dukkan-go/internal/data"
-	"gorm.io/gorm"
+	"go.uber.org/zap"
 )
 
-func (app *application) seed(db *gorm.DB) {
-	seedRolesAndUsers(db)
-	seedCategoriesAndProducts(db)
-}
+// seedPassword is the password of every seeded user.
+const seedPassword = "random"
+
+// $ go run ./cmd/api seed -size=3 -random-seed=42
+func seedCommand(cfg config, logger *zap.SugaredLogger, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	size := flags.Int("size", 1, "Multiplier of the seeded data, every size adds 10 users and 30 products")
+	randomSeed := flags.Int64("random-seed", 1, "Seed of the fake data, the same seed seeds the same data")
+	flags.Parse(args)
+
+	if cfg.env == "production" {
+		return errors.New("seed: refusing to seed a production database")
+	}
+	if *size < 1 {
+		return errors.New("seed: -size must be a positive number")
+	}
+
+	app, err := newApplication(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer app.close()
 
-func seedRolesAndUsers(db *gorm.DB) {
-	adminRole := &data.Role{Name: "admin"}
-	userRole := &data.Role{Name: "user"}
-	roles := []*data.Role{adminRole, userRole}
+	// faker reads from the global source as well.
+	rand.Seed(*randomSeed)
 
 	fmt.Println("seeding roles...")
-	for _, role := range roles {
-		db.Create(role)
+	if err := app.ensureRoles(); err != nil {
+		return err
 	}
 	fmt.Println("seeded roles completed!")
 
-	fmt.Println("seeding admin...")
-	admin := data.User{
-		FirstName:   "admin",
-		LastName:    "admin",
-		Email:       "lieqb2@gmail.com",
-		Address:     "kadikoy/istanbul",
-		IsActivated: true,
-		RoleID:      1,
+	if err := app.seedUsers(10 * *size); err != nil {
+		return err
+	}
+	if err := app.seedCategoriesAndProducts(30 * *size); err != nil {
+		return err
+	}
+
+	fmt.Printf("seeded users can log in with the password %q, add an admin with: create-admin -email=<email>\n", seedPassword)
+	return nil
+}
+
+func (app *application) seedUsers(n int) error {
+	role, err := app.models.Roles.GetByName("user")
+	if err != nil {
+		return err
+	}
+
+	// hashing is slow on purpose, every user gets the same hash.
+	var hashed data.User
+	if err := hashed.SetPassword(seedPassword); err != nil {
+		return err
 	}
-	admin.SetPassword("random")
-	db.Create(&admin)
-	fmt.Println("seeding admin completed")
 
 	fmt.Println("seeding users...")
-	for i := 0; i < 10; i++ {
+	skipped := 0
+	for i := 0; i < n; i++ {
 		user := data.User{
 			FirstName:   faker.FirstName(),
 			LastName:    faker.LastName(),
 			Email:       faker.Email(),
+			Password:    hashed.Password,
 			Address:     "somewhere over there",
 			IsActivated: i%2 == 0,
-			RoleID:      2,
+			RoleID:      role.ID,
+		}
+		if err := app.models.Users.Insert(&user); err != nil {
+			if errors.Is(err, data.ErrDuplicateRecord) {
+				skipped++
+				continue
+			}
+			return err
 		}
-		user.SetPassword("random")
-		db.Create(&user)
 	}
-	fmt.Println("seeded users completed!")
+	fmt.Printf("seeded users completed! (%d already existed)\n", skipped)
+	return nil
 }
 
-func seedCategoriesAndProducts(db *gorm.DB) {
-	rand.Seed(time.Now().UnixNano())
-
-	furniture := data.Category{Name: "furniture"}
-	electronics := data.Category{Name: "electronics"}
-	beauty := data.Category{Name: "beauty"}
-	deals := data.Category{Name: "deals"}
-
-	furniture.Slug = slug.Make(furniture.Name)
-	beauty.Slug = slug.Make(beauty.Name)
-	electronics.Slug = slug.Make(electronics.Name)
-	deals.Slug = slug.Make(deals.Name)
-
-	categories := []data.Category{furniture, electronics, beauty, deals}
-
+func (app *application) seedCategoriesAndProducts(n int) error {
 	fmt.Println("seeding categories...")
-	for _, category := range categories {
-		db.Create(&category)
+	var categories []*data.Category
+	for _, name := range []string{"furniture", "electronics", "beauty", "deals"} {
+		category, err := app.models.Categories.GetByName(name)
+		if errors.Is(err, data.ErrRecordNotFound) {
+			category = &data.Category{Name: name, Slug: slug.Make(name)}
+			err = app.models.Categories.Insert(category)
+		}
+		if err != nil {
+			return err
+		}
+		categories = append(categories, category)
 	}
 	fmt.Println("seeding categories completed!")
 
 	fmt.Println("seeding products...")
-	for i := 0; i < 30; i++ {
+	skipped := 0
+	for i := 0; i < n; i++ {
 		product := data.Product{
 			Name:        faker.Username(),
-			Description: faker.Username(),
+			Description: faker.Sentence(),
 			Brand:       faker.Username(),
 			Image:       "https://m.media-amazon.com/images/I/A1sKFc-P-6L._AC_UL320_.jpg",
-			Price:       float64(rand.Intn(5000)),
+			Price:       data.NewMoney(int64(rand.Intn(5000))*100, data.DefaultCurrency),
 			Count:       int64(rand.Intn(15)),
-			CategoryID:  int64(rand.Intn(len(categories)) + 1),
+			CategoryID:  categories[rand.Intn(len(categories))].ID,
 		}
-		product.Slug = data.Slugify(product.Name, 6)
+		// data.Slugify() has its own random source, the suffix has to
+		// come from the seeded one.
+		product.Slug = slug.Make(fmt.Sprintf("%s-%06d", product.Name, rand.Intn(1000000)))
 
-		db.Create(&product)
+		if err := app.models.Products.Insert(&product); err != nil {
+			if data.IsDuplicateRecord(err) {
+				skipped++
+				continue
+			}
+			return err
+		}
 	}
-	fmt.Println("seeding products completed!")
-
+	fmt.Printf("seeding products completed! (%d already existed)\n", skipped)
+	return nil
 }
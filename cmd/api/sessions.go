@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kubil6y/dukkan-go/internal/data"
+)
+
+// deleteAuthenticationTokenHandler() logs out the token of the request.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserContext(r)
+	token := app.getTokenContext(r)
+	if token == nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	if err := app.models.Tokens.DeleteSession(user.ID, token.ID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	e := envelope{"message": "success"}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) getSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserContext(r)
+
+	sessions, err := app.models.Tokens.GetSessions(user.ID, app.getTokenContext(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{"sessions": sessions}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.parseIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.getUserContext(r)
+	if err := app.models.Tokens.DeleteSession(user.ID, id); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	e := envelope{"message": "success"}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// deleteAllSessionsHandler() logs the user out everywhere, including this session.
+func (app *application) deleteAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserContext(r)
+
+	if err := app.models.Tokens.DeleteAllForUser(user.ID, data.ScopeAuthentication, data.ScopeRefresh); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{"message": "success"}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/kubil6y/dukkan-go/internal/data"
+	"github.com/kubil6y/dukkan-go/internal/email"
+	"github.com/kubil6y/dukkan-go/internal/jobs"
+	"github.com/kubil6y/dukkan-go/internal/oidc"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func setupFlags(cfg *config) {
@@ -22,20 +32,116 @@ func setupFlags(cfg *config) {
 	flag.StringVar(&cfg.env, "env", os.Getenv("ENV"), "Server Environment {development|production}")
 	flag.StringVar(&cfg.domain, "domain", os.Getenv("DOMAIN"), "Application domain")
 	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("DUKKAN_DB_DSN"), "Database DSN")
+	flag.BoolVar(&cfg.db.schemaCheck, "db-schema-check", true, "Refuse to start when database migrations are pending")
 
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	flag.StringVar(&cfg.cursor.secret, "cursor-secret", os.Getenv("DUKKAN_CURSOR_SECRET"), "Secret used to sign pagination cursors")
+	flag.DurationVar(&cfg.tokens.accessTTL, "access-token-ttl", 15*time.Minute, "Lifetime of authentication (access) tokens")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "refresh-token-ttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+	flag.IntVar(&cfg.login.maxAttempts, "login-max-attempts", 5, "Failed logins before an account is locked")
+	flag.DurationVar(&cfg.login.lockout, "login-lockout", time.Minute, "First lockout duration, doubled on every further failure")
+	flag.DurationVar(&cfg.login.maxLockout, "login-max-lockout", time.Hour, "Maximum lockout duration")
+	flag.DurationVar(&cfg.login.window, "login-window", 24*time.Hour, "Failed logins older than this are forgotten")
+	flag.StringVar(&cfg.twoFactor.issuer, "2fa-issuer", "Dukkan", "Issuer name shown in authenticator apps")
+	flag.BoolVar(&cfg.twoFactor.requireAdmin, "2fa-require-admin", false, "Require two factor authentication for the admin role, other roles set require_two_factor")
+	flag.StringVar(&cfg.passwords.Algorithm, "password-hash", data.HashArgon2id, "Hash algorithm of new passwords {argon2id|bcrypt}")
+	flag.IntVar(&cfg.passwords.BcryptCost, "bcrypt-cost", bcrypt.DefaultCost, "bcrypt cost of new password hashes")
+	argon2Memory := flag.Uint("argon2-memory", 64*1024, "argon2id memory of new password hashes in KiB")
+	argon2Iterations := flag.Uint("argon2-iterations", 1, "argon2id iterations of new password hashes")
+	argon2Parallelism := flag.Uint("argon2-parallelism", 2, "argon2id parallelism of new password hashes")
+	flag.StringVar(&cfg.smtp.host, "smtp-host", os.Getenv("DUKKAN_SMTP_HOST"), "SMTP host, emails are only logged when it is empty")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 587, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("DUKKAN_SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("DUKKAN_SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Dukkan <no-reply@dukkan.com>", "Sender of emails")
+	flag.StringVar(&cfg.smtp.dir, "smtp-dir", "", "Write emails as .eml files into the directory instead of logging them, when there is no SMTP host")
+	flag.BoolVar(&cfg.smtp.logBody, "smtp-log-body", false, "Log the text of emails, codes included, when there is no SMTP host (ignored in production)")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second, "How long in-flight requests, and then background work, can take on shutdown")
+	flag.IntVar(&cfg.jobs.workers, "job-workers", 2, "Background jobs run at the same time, 0 disables the job worker")
+	flag.DurationVar(&cfg.jobs.deadRetention, "job-dead-retention", 7*24*time.Hour, "How long dead jobs are kept before they are deleted, 0 keeps them")
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "How long Idempotency-Key responses are kept")
+
 	//$ go run ./cmd/api -cors-trusted-origins="https://www.example.com https://staging.example.com"
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
 		cfg.cors.trustedOrigins = strings.Fields(val)
 		return nil
 	})
 
+	//$ go run ./cmd/api -oidc-provider="name=google,issuer=https://accounts.google.com,client_id=...,client_secret=...,redirect_url=..."
+	// the flag can be repeated, DUKKAN_OIDC_PROVIDERS holds the same values separated by ';'
+	for _, spec := range strings.Split(os.Getenv("DUKKAN_OIDC_PROVIDERS"), ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		if err := addOIDCProvider(cfg, spec); err != nil {
+			log.Fatal(err)
+		}
+	}
+	flag.Func("oidc-provider", "OpenID Connect provider (name=,issuer=,client_id=,client_secret=,redirect_url=)", func(val string) error {
+		return addOIDCProvider(cfg, val)
+	})
+
 	flag.Parse()
+
+	cfg.passwords.Memory = uint32(*argon2Memory)
+	cfg.passwords.Iterations = uint32(*argon2Iterations)
+	cfg.passwords.Parallelism = uint8(*argon2Parallelism)
 }
 
+// newMailer() returns the SMTP mailer when a host is configured, emails are
+// written to files or logged in development.
+func newMailer(cfg config, logger *zap.SugaredLogger) email.Mailer {
+	switch {
+	case cfg.smtp.host != "":
+		return email.SMTPMailer{
+			Host:     cfg.smtp.host,
+			Port:     cfg.smtp.port,
+			Username: cfg.smtp.username,
+			Password: cfg.smtp.password,
+		}
+	case cfg.smtp.dir != "":
+		return email.FileMailer{Dir: cfg.smtp.dir}
+	default:
+		// codes are never logged in production.
+		return email.LogMailer{Logger: logger, Body: cfg.smtp.logBody && cfg.env != "production"}
+	}
+}
+
+func addOIDCProvider(cfg *config, spec string) error {
+	var c oidc.Config
+	for _, pair := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid oidc provider option %q", pair)
+		}
+		switch kv[0] {
+		case "name":
+			c.Name = kv[1]
+		case "issuer":
+			c.Issuer = kv[1]
+		case "client_id":
+			c.ClientID = kv[1]
+		case "client_secret":
+			c.ClientSecret = kv[1]
+		case "redirect_url":
+			c.RedirectURL = kv[1]
+		default:
+			return fmt.Errorf("unknown oidc provider option %q", kv[0])
+		}
+	}
+	if c.Name == "" || c.Issuer == "" || c.ClientID == "" || c.RedirectURL == "" {
+		return errors.New("oidc provider needs name, issuer, client_id and redirect_url")
+	}
+	cfg.oidc.providers = append(cfg.oidc.providers, c)
+	return nil
+}
+
+// serve() runs the server until SIGINT or SIGTERM. In-flight requests get
+// shutdownTimeout to finish, then background work is stopped and gets
+// shutdownTimeout as well.
 func (app *application) serve() error {
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%s", app.config.port),
@@ -44,6 +150,108 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// the shutdown goroutine uses app.worker.
+	app.startJobWorker()
+
+	shutdownError := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.logger.Infow("shutting down server", "signal", s.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+
+		// the background work is drained even when requests timed out.
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			app.logger.Errorw("requests did not finish in time", "error", err)
+		} else {
+			app.logger.Info("requests finished")
+		}
+
+		app.logger.Info("waiting for background work")
+		close(app.shutdown)
+		if app.waitBackground(app.config.shutdownTimeout) {
+			app.logger.Info("background work finished")
+		}
+
+		shutdownError <- err
+	}()
+
 	app.logger.Infof("%s server is running on port :%s", app.config.env, app.config.port)
-	return srv.ListenAndServe()
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	if err := <-shutdownError; err != nil {
+		return err
+	}
+	app.logger.Infof("%s server stopped", app.config.env)
+	return nil
+}
+
+// abortGracePeriod is how long canceled jobs get to return on shutdown.
+const abortGracePeriod = 5 * time.Second
+
+// waitBackground() waits for the background goroutines until timeout, then
+// the running jobs are canceled. Work which did not finish is logged and
+// abandoned, abandoned jobs run again once they are stale.
+func (app *application) waitBackground(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+	}
+
+	if app.worker == nil {
+		app.logger.Error("background work did not finish in time, abandoning it")
+		return false
+	}
+
+	app.logger.Warnw("background work did not finish in time, canceling jobs", "jobs", app.worker.Running())
+	app.worker.Abort()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(abortGracePeriod):
+		app.logger.Errorw("abandoning background work", "jobs", app.worker.Running())
+		return false
+	}
+}
+
+// startJobWorker() runs the job worker until the server shuts down,
+// jobs which are running then are finished first.
+func (app *application) startJobWorker() {
+	if app.config.jobs.workers <= 0 {
+		return
+	}
+
+	worker := jobs.NewWorker(app.models.Jobs, app.logger)
+	worker.Concurrency = app.config.jobs.workers
+	worker.DeadRetention = app.config.jobs.deadRetention
+	app.registerJobs(worker)
+	app.worker = worker
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-app.shutdown
+		cancel()
+	}()
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		worker.Run(ctx)
+		app.logger.Info("job worker stopped")
+	}()
 }
@@ -2,12 +2,10 @@ package main
 
 import (
 	"errors"
-	"fmt"
 	"net/http"
-	"time"
+	"strings"
 
 	"github.com/kubil6y/dukkan-go/internal/data"
-	"github.com/kubil6y/dukkan-go/internal/email"
 	"github.com/kubil6y/dukkan-go/internal/validator"
 )
 
@@ -25,45 +23,34 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+	user, ok := app.checkCredentials(w, r, input.Email, input.Password)
+	if !ok {
 		return
 	}
 
-	matches, err := user.ComparePassword(input.Password)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-	if !matches {
-		app.invalidCredentialsResponse(w, r)
+	if user.TwoFactorEnabled {
+		app.writeTwoFactorChallenge(w, r, user)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeAuthentication)
+	token, refresh, err := app.models.Tokens.NewSession(user.ID, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, r.UserAgent(), app.clientIP(r))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Deleting auth tokens, keeping only last 5 on the background with a goroutine.
-	app.background(func() {
-		fmt.Println("trying to delete tokens") // TODO
-		if err := app.models.Tokens.KeepLastFiveAuthTokens(user.ID); err != nil {
-			app.logger.Error("Error deleting tokens")
-		}
-	})
+	// Deleting old sessions, keeping only last 5 with a background job.
+	app.enqueue(r, jobKeepLastFiveTokens, keepLastFiveTokensJob{UserID: user.ID})
 
-	e := envelope{"authentication_token": map[string]interface{}{
-		"token":  token.Plaintext,
-		"expiry": token.Expiry,
-	}}
+	e := envelope{
+		"authentication_token": map[string]interface{}{
+			"token":  token.Plaintext,
+			"expiry": token.Expiry,
+		},
+		"refresh_token": map[string]interface{}{
+			"token":  refresh.Plaintext,
+			"expiry": refresh.Expiry,
+		}}
 	out := app.outOK(e)
 	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -124,30 +111,75 @@ func (app *application) generateActivationTokenHandler(w http.ResponseWriter, r
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	// the response is the same whether the user exists or not, the code
+	// is only sent by email so this endpoint can't activate other accounts.
+	e := envelope{"message": "an email will be sent to you containing activation instructions"}
+	out := app.outOK(e)
+
+	user, err := app.models.Users.GetByEmail(strings.ToLower(input.Email))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
+			if err := app.writeJSON(w, http.StatusAccepted, out, nil); err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 1*time.Hour, data.ScopeActivation)
-	if err != nil {
+	// the job creates the code, activated users get no email.
+	if !user.IsActivated {
+		app.enqueue(r, jobActivationEmail, activationEmailJob{UserID: user.ID})
+	}
+
+	if err := app.writeJSON(w, http.StatusAccepted, out, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+}
 
-	app.background(func() {
-		email.ActivationEmail(user, token.Plaintext)
-	})
+// refreshTokenHandler() rotates the refresh token, the client gets a new access
+// and refresh token pair. A refresh token can only be used once, using it again
+// logs out every session that came from the same login.
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input refreshTokenDTO
 
-	e := envelope{
-		"code": token.Plaintext,
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
+
+	token, refresh, err := app.models.Tokens.Rotate(input.RefreshToken, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, r.UserAgent(), app.clientIP(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			app.logger.Warnw("refresh token reused, token family revoked", "ip", app.clientIP(r))
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	e := envelope{
+		"authentication_token": map[string]interface{}{
+			"token":  token.Plaintext,
+			"expiry": token.Expiry,
+		},
+		"refresh_token": map[string]interface{}{
+			"token":  refresh.Plaintext,
+			"expiry": refresh.Expiry,
+		}}
 	out := app.outOK(e)
 	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -171,47 +203,117 @@ func (app *application) loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, ok := app.checkCredentials(w, r, input.Email, input.Password)
+	if !ok {
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		app.writeTwoFactorChallenge(w, r, user)
+		return
+	}
+
+	token, refresh, err := app.models.Tokens.NewSession(user.ID, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, r.UserAgent(), app.clientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Deleting old sessions, keeping only last 5 with a background job.
+	app.enqueue(r, jobKeepLastFiveTokens, keepLastFiveTokensJob{UserID: user.ID})
+
+	e := envelope{
+		"user": user,
+		"authentication_token": map[string]interface{}{
+			"token":  token.Plaintext,
+			"expiry": token.Expiry,
+		},
+		"refresh_token": map[string]interface{}{
+			"token":  refresh.Plaintext,
+			"expiry": refresh.Expiry,
+		}}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input passwordResetTokenDTO
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the response is the same whether the user exists or not,
+	// so this endpoint can't be used to find registered emails.
+	e := envelope{"message": "an email will be sent to you containing password reset instructions"}
+	out := app.outOK(e)
+
+	user, err := app.models.Users.GetByEmail(strings.ToLower(input.Email))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			if err := app.writeJSON(w, http.StatusAccepted, out, nil); err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	matches, err := user.ComparePassword(input.Password)
-	if err != nil {
+	app.enqueue(r, jobPasswordResetEmail, passwordResetEmailJob{UserID: user.ID})
+
+	if err := app.writeJSON(w, http.StatusAccepted, out, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	if !matches {
-		app.invalidCredentialsResponse(w, r)
+}
+
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input resetPasswordDTO
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeAuthentication)
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.Code)
 	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "invalid or expired password reset code")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := user.SetPassword(input.Password); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Deleting auth tokens, keeping only last 5 on the background with a goroutine.
-	app.background(func() {
-		fmt.Println("trying to delete tokens") // TODO
-		if err := app.models.Tokens.KeepLastFiveAuthTokens(user.ID); err != nil {
-			app.logger.Error("Error deleting tokens")
-		}
-	})
+	if err := app.models.Tokens.ResetPassword(user); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	e := envelope{
-		"user": user,
-		"authentication_token": map[string]interface{}{
-			"token":  token.Plaintext,
-			"expiry": token.Expiry,
-		}}
+	e := envelope{"message": "your password was successfully reset"}
 	out := app.outOK(e)
 	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kubil6y/dukkan-go/internal/data"
+	"github.com/kubil6y/dukkan-go/internal/totp"
+	"github.com/kubil6y/dukkan-go/internal/validator"
+)
+
+// writeTwoFactorChallenge() is the first step of logging in with two factor
+// authentication, the client sends the token with a code to verifyTwoFactorHandler.
+func (app *application) writeTwoFactorChallenge(w http.ResponseWriter, r *http.Request, user *data.User) {
+	token, err := app.models.Tokens.New(user.ID, 5*time.Minute, data.ScopeTwoFactor)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{
+		"two_factor_required": true,
+		"two_factor_token": map[string]interface{}{
+			"token":  token.Plaintext,
+			"expiry": token.Expiry,
+		}}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusAccepted, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// verifyTwoFactorHandler() is the second step of logging in, it trades
+// the two factor token and a TOTP or recovery code for a session.
+func (app *application) verifyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input verifyTwoFactorDTO
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeTwoFactor, input.TwoFactorToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// wrong codes count as failed logins, so codes can not be guessed either.
+	attempt, err := app.models.LoginAttempts.Get(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if attempt.IsLocked() {
+		app.loginLockedResponse(w, r, *attempt.LockedUntil)
+		return
+	}
+
+	if err := app.models.TwoFactor.Verify(user, input.Code, input.RecoveryCode); err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidTwoFactorCode), errors.Is(err, data.ErrTwoFactorDisabled):
+			app.failedLoginResponse(w, r, user.Email, app.invalidTwoFactorCodeResponse)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.models.Tokens.DeleteAllForUser(user.ID, data.ScopeTwoFactor); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if attempt.Failures > 0 {
+		if err := app.models.LoginAttempts.Reset(user.Email); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	token, refresh, err := app.models.Tokens.NewSession(user.ID, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, r.UserAgent(), app.clientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{
+		"user": user,
+		"authentication_token": map[string]interface{}{
+			"token":  token.Plaintext,
+			"expiry": token.Expiry,
+		},
+		"refresh_token": map[string]interface{}{
+			"token":  refresh.Plaintext,
+			"expiry": refresh.Expiry,
+		}}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// enrollTwoFactorHandler() creates a new secret, the user adds it to an
+// authenticator app and confirms it with confirmTwoFactorHandler.
+func (app *application) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserContext(r)
+
+	secret, err := app.models.TwoFactor.Enroll(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTwoFactorEnabled):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	e := envelope{
+		"secret": secret,
+		"uri":    totp.URI(app.config.twoFactor.issuer, user.Email, secret),
+	}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// confirmTwoFactorHandler() enables two factor authentication, recovery
+// codes are only shown in this response.
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input twoFactorDTO
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.getUserContext(r)
+	codes, err := app.models.TwoFactor.Confirm(user, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTwoFactorEnabled), errors.Is(err, data.ErrTwoFactorDisabled):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, data.ErrInvalidTwoFactorCode):
+			app.invalidTwoFactorCodeResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	e := envelope{"recovery_codes": codes}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	var input twoFactorDTO
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.getUserContext(r)
+	if err := app.models.TwoFactor.Verify(user, input.Code, ""); err != nil {
+		switch {
+		case errors.Is(err, data.ErrTwoFactorDisabled):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, data.ErrInvalidTwoFactorCode):
+			app.invalidTwoFactorCodeResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	codes, err := app.models.TwoFactor.RegenerateRecoveryCodes(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{"recovery_codes": codes}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input disableTwoFactorDTO
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.getUserContext(r)
+	matches, err := user.ComparePassword(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !matches {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	if err := app.models.TwoFactor.Verify(user, input.Code, ""); err != nil {
+		switch {
+		case errors.Is(err, data.ErrTwoFactorDisabled):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, data.ErrInvalidTwoFactorCode):
+			app.invalidTwoFactorCodeResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.models.TwoFactor.Disable(user); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	e := envelope{"message": "success"}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
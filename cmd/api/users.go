@@ -3,10 +3,8 @@ package main
 import (
 	"errors"
 	"net/http"
-	"time"
 
 	"github.com/kubil6y/dukkan-go/internal/data"
-	"github.com/kubil6y/dukkan-go/internal/email"
 	"github.com/kubil6y/dukkan-go/internal/validator"
 )
 
@@ -45,15 +43,7 @@ func (app *application) registerHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 1*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	app.background(func() {
-		email.ActivationEmail(&user, token.Plaintext)
-	})
+	app.enqueue(r, jobActivationEmail, activationEmailJob{UserID: user.ID})
 
 	e := envelope{"message": "success"}
 	out := app.outOK(e)
@@ -139,13 +129,117 @@ func (app *application) editProfileHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	user := app.getUserContext(r)
-	input.populate(user)
+
+	// a new email or password needs the current password, so a stolen token is
+	// not enough to take over the account. The email is stored as pending until
+	// it is confirmed.
+	var newEmail string
+	if input.Email != nil && sanitize(*input.Email) != user.Email {
+		newEmail = sanitize(*input.Email)
+	}
+
+	if newEmail != "" || input.Password != nil {
+		matches, err := user.ComparePassword(*input.CurrentPassword)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !matches {
+			app.invalidCredentialsResponse(w, r)
+			return
+		}
+	}
+
+	if newEmail != "" {
+		_, err := app.models.Users.GetByEmail(newEmail)
+		switch {
+		case err == nil:
+			v.AddError("email", "a user with the email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		case !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if err := input.populate(user); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
 	if err := app.models.Users.Update(user); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	// a new password logs out every other session, this one stays.
+	if input.Password != nil {
+		if err := app.models.Tokens.DeleteOtherSessions(user.ID, app.getTokenContext(r)); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if newEmail != "" {
+		if err := app.models.Tokens.SetPendingEmail(user, newEmail); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.enqueue(r, jobEmailChangeEmail, emailChangeEmailJob{UserID: user.ID, NewEmail: newEmail})
+		app.enqueue(r, jobEmailChangeNotice, emailChangeNoticeJob{UserID: user.ID, NewEmail: newEmail})
+	}
+
+	e := envelope{"user": user}
+	out := app.outOK(e)
+	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// confirmEmailChangeHandler() swaps the email of the user with the pending one,
+// the code is sent to the new address by editProfileHandler().
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input confirmEmailChangeDTO
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "invalid or expired code")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.models.Tokens.ConfirmEmailChange(user); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "invalid or expired code")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateRecord):
+			v.AddError("email", "a user with the email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	e := envelope{"user": user}
 	out := app.outOK(e)
 	if err := app.writeJSON(w, http.StatusOK, out, nil); err != nil {
@@ -0,0 +1,135 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const apiKeyPrefix = "dk_"
+
+// APIKey lets other systems call the admin API without a user account.
+// The plain key looks like dk_ABCD2345.<secret>, only its hash is stored,
+// Prefix (dk_ABCD2345) is kept so keys can be told apart in listings.
+type APIKey struct {
+	CoreModel
+	Name        string      `json:"name" gorm:"not null"`
+	Prefix      string      `json:"prefix" gorm:"uniqueIndex;not null"`
+	Plaintext   string      `json:"key,omitempty" gorm:"-"`
+	Hash        []byte      `json:"-" gorm:"uniqueIndex;not null"`
+	Expiry      *time.Time  `json:"expiry"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+	CreatedByID int64       `json:"created_by_id" gorm:"not null"`
+	Permissions Permissions `json:"permissions" gorm:"many2many:api_key_permissions;constraint:OnDelete:CASCADE"`
+}
+
+func (k *APIKey) IsExpired() bool {
+	return k.Expiry != nil && k.Expiry.Before(time.Now())
+}
+
+// Principal() returns the service principal of the key, a user without an
+// account whose role only has the permissions of the key.
+func (k *APIKey) Principal() *User {
+	return &User{
+		FirstName:   k.Name,
+		IsActivated: true,
+		Role:        &Role{Name: "api-key", Permissions: k.Permissions},
+		APIKey:      k,
+	}
+}
+
+// IsAPIKey() reports whether the string has the shape of a plain API key.
+func IsAPIKey(s string) bool {
+	return strings.HasPrefix(s, apiKeyPrefix) && len(s) == len(apiKeyPrefix)+8+1+26 && s[len(apiKeyPrefix)+8] == '.'
+}
+
+func generateAPIKey() (prefix, plaintext string, err error) {
+	b := make([]byte, 21)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	s := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	prefix = apiKeyPrefix + s[:8]
+	return prefix, prefix + "." + s[8:34], nil
+}
+
+type APIKeyModel struct {
+	DB *gorm.DB
+}
+
+// Insert() generates the key, APIKey.Plaintext is only set here.
+func (m APIKeyModel) Insert(key *APIKey) error {
+	prefix, plaintext, err := generateAPIKey()
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256([]byte(plaintext))
+	key.Prefix = prefix
+	key.Plaintext = plaintext
+	key.Hash = hash[:]
+
+	return m.DB.Create(key).Error
+}
+
+func (m APIKeyModel) GetAll() ([]APIKey, error) {
+	var keys []APIKey
+	if err := m.DB.Preload("Permissions").Order("id").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (m APIKeyModel) GetByID(id int64) (*APIKey, error) {
+	var key APIKey
+	if err := m.DB.Where("id=?", id).First(&key).Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &key, nil
+}
+
+// GetForKey() returns the unexpired key with its permissions.
+func (m APIKeyModel) GetForKey(plaintext string) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	var key APIKey
+	err := m.DB.
+		Where("hash=? and (expiry IS NULL or expiry > ?)", hash[:], time.Now()).
+		Preload("Permissions").
+		First(&key).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &key, nil
+}
+
+// Touch() records the use of the key, at most once a minute to keep writes low.
+func (m APIKeyModel) Touch(key *APIKey) error {
+	now := time.Now()
+	return m.DB.Model(&APIKey{}).
+		Where("id=? and (last_used_at IS NULL or last_used_at < ?)", key.ID, now.Add(-time.Minute)).
+		UpdateColumn("last_used_at", now).Error
+}
+
+// Delete() revokes the key.
+func (m APIKeyModel) Delete(key *APIKey) error {
+	if err := m.DB.Model(key).Association("Permissions").Clear(); err != nil {
+		return err
+	}
+	return m.DB.Delete(key).Error
+}
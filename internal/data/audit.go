@@ -0,0 +1,61 @@
+package data
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Audit actions, new actions should be added here so the log stays searchable.
+const (
+	AuditLoginLocked   = "login.locked"
+	AuditLoginUnlocked = "login.unlocked"
+)
+
+// AuditEvent is an append only record of a security related event.
+// ActorID is the user who caused the event, it is nil for anonymous clients.
+type AuditEvent struct {
+	ID        int64     `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at" gorm:"index;not null"`
+	Action    string    `json:"action" gorm:"index;not null"`
+	ActorID   *int64    `json:"actor_id,omitempty"`
+	Email     string    `json:"email,omitempty" gorm:"index;not null;default:''"`
+	IP        string    `json:"ip" gorm:"not null;default:''"`
+	Details   string    `json:"details,omitempty" gorm:"not null;default:''"`
+}
+
+type AuditModel struct {
+	DB *gorm.DB
+}
+
+func (m AuditModel) Record(event *AuditEvent) error {
+	return m.DB.Create(event).Error
+}
+
+// GetAll() returns the events in the order they happened, action and email are optional filters.
+func (m AuditModel) GetAll(p *Paginate, action, email string) ([]AuditEvent, Metadata, error) {
+	filter := func(db *gorm.DB) *gorm.DB {
+		if action != "" {
+			db = db.Where("action=?", action)
+		}
+		if email != "" {
+			db = db.Where("email=?", email)
+		}
+		return db
+	}
+
+	var events []AuditEvent
+	err := m.DB.Scopes(filter).Order("id").Scopes(p.PaginatedResults).Find(&events).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	p.Arrange(events)
+
+	var total int64
+	m.DB.Model(&AuditEvent{}).Scopes(filter).Count(&total)
+	metadata := CalculateMetadata(p, int(total))
+	if len(events) > 0 {
+		metadata.SetCursors(p, events[0].ID, events[len(events)-1].ID, len(events))
+	}
+	return events, metadata, nil
+}
@@ -0,0 +1,284 @@
+package data
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxCartItemQuantity is the largest quantity of a product in the cart.
+const MaxCartItemQuantity = 100
+
+var (
+	ErrEmptyCart        = errors.New("cart is empty")
+	ErrCartItemQuantity = errors.New("cart item quantity is too large")
+)
+
+// Cart is the server side shopping cart, every user has at most one.
+type Cart struct {
+	CoreModel
+	UserID    int64      `json:"user_id" gorm:"uniqueIndex;not null"`
+	CartItems []CartItem `json:"cart_items" gorm:"foreignKey:CartID;constraint:OnDelete:CASCADE"`
+}
+
+// CartItem.VariantID is zero for products without variants,
+// it is not a foreign key so it can take part in the unique index.
+type CartItem struct {
+	CoreModel
+	CartID    int64           `json:"cart_id" gorm:"uniqueIndex:idx_cart_product;not null"`
+	ProductID int64           `json:"product_id" gorm:"uniqueIndex:idx_cart_product;not null"`
+	Product   *Product        `json:"product,omitempty" gorm:"constraint:OnDelete:CASCADE"`
+	VariantID int64           `json:"variant_id,omitempty" gorm:"uniqueIndex:idx_cart_product;not null;default:0"`
+	Variant   *ProductVariant `json:"variant,omitempty" gorm:"-"`
+	Quantity  int64           `json:"quantity" gorm:"not null"`
+}
+
+// CartLine is a cart item with the live price and stock of its product.
+type CartLine struct {
+	CartItem
+	UnitPrice  Money  `json:"unit_price"`
+	LineTotal  Money  `json:"line_total"`
+	InStock    bool   `json:"in_stock"`
+	StockCount int64  `json:"stock_count"`
+	Warning    string `json:"warning,omitempty"`
+}
+
+// CartView is what clients get for their cart, totals are calculated
+// on every request so they always show the current prices.
+type CartView struct {
+	ID       int64      `json:"id"`
+	Lines    []CartLine `json:"lines"`
+	Total    Money      `json:"total"`
+	Warnings []string   `json:"warnings,omitempty"`
+}
+
+func NewCartView(cart *Cart) *CartView {
+	view := &CartView{
+		ID:    cart.ID,
+		Lines: []CartLine{},
+		Total: NewMoney(0, DefaultCurrency),
+	}
+
+	for i, item := range cart.CartItems {
+		line := CartLine{CartItem: item}
+		if item.Product != nil {
+			line.UnitPrice = item.Product.Price
+			line.StockCount = item.Product.Count
+			if item.Variant != nil {
+				line.UnitPrice = item.Variant.PriceFor(item.Product)
+				line.StockCount = item.Variant.Count
+			}
+			line.LineTotal = line.UnitPrice.Mul(item.Quantity)
+			line.InStock = line.StockCount >= item.Quantity
+
+			switch {
+			case item.VariantID != 0 && item.Variant == nil:
+				line.InStock = false
+				line.Warning = "product variant is no longer available"
+			case line.StockCount == 0:
+				line.Warning = "product is out of stock"
+			case !line.InStock:
+				line.Warning = "only a limited quantity of this product is in stock"
+			}
+
+			if i == 0 {
+				view.Total = line.LineTotal
+			} else if total, err := view.Total.Add(line.LineTotal); err == nil {
+				view.Total = total
+			} else {
+				line.Warning = "product has a different currency than the rest of the cart"
+			}
+		}
+
+		if line.Warning != "" {
+			view.Warnings = append(view.Warnings, line.Warning)
+		}
+		view.Lines = append(view.Lines, line)
+	}
+
+	return view
+}
+
+type CartModel struct {
+	DB *gorm.DB
+}
+
+// GetForUser() returns the user's cart, creating an empty one if needed.
+func (m CartModel) GetForUser(userID int64) (*Cart, error) {
+	cart := Cart{UserID: userID}
+	err := m.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&cart).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.DB.
+		Where("user_id=?", userID).
+		Preload("CartItems", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id")
+		}).
+		Preload("CartItems.Product").
+		First(&cart).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var variantIDs []int64
+	for _, item := range cart.CartItems {
+		if item.VariantID != 0 {
+			variantIDs = append(variantIDs, item.VariantID)
+		}
+	}
+	if len(variantIDs) > 0 {
+		var variants []ProductVariant
+		if err := m.DB.Where("id IN ?", variantIDs).Find(&variants).Error; err != nil {
+			return nil, err
+		}
+		for i := range cart.CartItems {
+			for j := range variants {
+				if cart.CartItems[i].VariantID == variants[j].ID {
+					cart.CartItems[i].Variant = &variants[j]
+				}
+			}
+		}
+	}
+
+	return &cart, nil
+}
+
+// SetItem() sets the quantity of a product in the cart, adding it when missing.
+func (m CartModel) SetItem(cart *Cart, productID, variantID, quantity int64) error {
+	item := CartItem{
+		CartID:    cart.ID,
+		ProductID: productID,
+		VariantID: variantID,
+		Quantity:  quantity,
+	}
+	return m.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "cart_id"}, {Name: "product_id"}, {Name: "variant_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"quantity", "updated_at"}),
+	}).Create(&item).Error
+}
+
+// AddItem() adds quantity to the product in the cart in a single upsert, so
+// concurrent requests never lose an update. ErrCartItemQuantity means the
+// summed quantity would be more than MaxCartItemQuantity.
+func (m CartModel) AddItem(cart *Cart, productID, variantID, quantity int64) error {
+	if quantity > MaxCartItemQuantity {
+		return ErrCartItemQuantity
+	}
+
+	item := CartItem{
+		CartID:    cart.ID,
+		ProductID: productID,
+		VariantID: variantID,
+		Quantity:  quantity,
+	}
+	res := m.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "cart_id"}, {Name: "product_id"}, {Name: "variant_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"quantity":   gorm.Expr("cart_items.quantity + EXCLUDED.quantity"),
+			"updated_at": gorm.Expr("EXCLUDED.updated_at"),
+		}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			gorm.Expr("cart_items.quantity + EXCLUDED.quantity <= ?", MaxCartItemQuantity),
+		}},
+	}).Create(&item)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrCartItemQuantity
+	}
+	return nil
+}
+
+func (m CartModel) GetItemByID(cart *Cart, id int64) (*CartItem, error) {
+	var item CartItem
+	err := m.DB.Where("cart_id=? and id=?", cart.ID, id).First(&item).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &item, nil
+}
+
+func (m CartModel) DeleteItem(item *CartItem) error {
+	return m.DB.Delete(item).Error
+}
+
+func (m CartModel) Clear(cart *Cart) error {
+	return m.DB.Where("cart_id=?", cart.ID).Delete(&CartItem{}).Error
+}
+
+// OrderDTO() turns the cart into the input of OrderModel.CreateOrder().
+func (c *Cart) OrderDTO(paymentMethod string) (CreateOrderDTO, error) {
+	if len(c.CartItems) == 0 {
+		return CreateOrderDTO{}, ErrEmptyCart
+	}
+
+	dto := CreateOrderDTO{PaymentMethod: paymentMethod}
+	for _, item := range c.CartItems {
+		dto.OrderItems = append(dto.OrderItems, OrderItemDTO{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Quantity:  item.Quantity,
+		})
+	}
+	return dto, nil
+}
+
+// Checkout() creates an order from the cart with the same logic as
+// OrderModel.CreateOrder() and empties the cart in the same transaction.
+// The cart and its items are locked and read again inside the transaction,
+// concurrent checkouts of the same cart can't order it twice and items
+// changed in the meantime are ordered as they are now.
+func (m CartModel) Checkout(cart *Cart, paymentMethod string) (*Order, error) {
+	tx := m.DB.Begin()
+
+	var locked Cart
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id=?", cart.ID).First(&locked).Error
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("cart_id=?", cart.ID).Order("id").Find(&locked.CartItems).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	dto, err := locked.OrderDTO(paymentMethod)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	order, err := createOrderTx(tx, locked.UserID, dto)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Where("cart_id=?", cart.ID).Delete(&CartItem{}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	cart.CartItems = nil
+	return order, nil
+}
@@ -0,0 +1,68 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursorKey signs pagination cursors, so clients can not forge them.
+// It is random unless SetCursorKey() is called, cursors then don't survive restarts.
+var cursorKey = func() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}()
+
+func SetCursorKey(key []byte) {
+	if len(key) > 0 {
+		cursorKey = key
+	}
+}
+
+// EncodeCursor() returns an opaque cursor for the row id: base64(id).base64(hmac)
+func EncodeCursor(id int64) string {
+	payload := []byte(strconv.FormatInt(id, 10))
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write(payload)
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func DecodeCursor(cursor string) (int64, error) {
+	parts := strings.Split(cursor, ".")
+	if len(parts) != 2 {
+		return 0, ErrInvalidCursor
+	}
+
+	enc := base64.RawURLEncoding
+	payload, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	signature, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)[:16]) {
+		return 0, ErrInvalidCursor
+	}
+
+	id, err := strconv.ParseInt(string(payload), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
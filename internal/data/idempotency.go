@@ -0,0 +1,71 @@
+package data
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// IdempotencyKey stores the response of a request sent with an Idempotency-Key header,
+// so retries of the same request get the same response instead of running twice.
+// StatusCode is zero while the original request is still being processed.
+type IdempotencyKey struct {
+	CoreModel
+	Key          string    `json:"key" gorm:"uniqueIndex:idx_idempotency_user_key;not null"`
+	UserID       int64     `json:"user_id" gorm:"uniqueIndex:idx_idempotency_user_key;not null"`
+	RequestHash  []byte    `json:"-" gorm:"not null"`
+	StatusCode   int       `json:"status_code" gorm:"not null"`
+	ContentType  string    `json:"-" gorm:"not null"`
+	ResponseBody []byte    `json:"-"`
+	Expiry       time.Time `json:"expiry" gorm:"index;not null"`
+}
+
+func (k *IdempotencyKey) IsCompleted() bool {
+	return k.StatusCode != 0
+}
+
+type IdempotencyKeyModel struct {
+	DB *gorm.DB
+}
+
+func (m IdempotencyKeyModel) Get(userID int64, key string) (*IdempotencyKey, error) {
+	var idempotencyKey IdempotencyKey
+	err := m.DB.Where("user_id=? and key=? and expiry > ?", userID, key, time.Now()).First(&idempotencyKey).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &idempotencyKey, nil
+}
+
+// Insert() reserves the key, ErrDuplicateRecord means
+// another request with the same key got there first.
+func (m IdempotencyKeyModel) Insert(k *IdempotencyKey) error {
+	// expired keys of the user are removed first, so they can be used again.
+	if err := m.DB.Where("user_id=? and expiry <= ?", k.UserID, time.Now()).Delete(&IdempotencyKey{}).Error; err != nil {
+		return err
+	}
+
+	if err := m.DB.Create(k).Error; err != nil {
+		switch {
+		case IsDuplicateRecord(err):
+			return ErrDuplicateRecord
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m IdempotencyKeyModel) Complete(k *IdempotencyKey) error {
+	return m.DB.Model(k).Select("status_code", "content_type", "response_body").Updates(k).Error
+}
+
+func (m IdempotencyKeyModel) Delete(k *IdempotencyKey) error {
+	return m.DB.Delete(k).Error
+}
@@ -0,0 +1,213 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/kubil6y/dukkan-go/internal/oidc"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrUnverifiedEmail      = errors.New("email of the identity is not verified")
+	ErrIdentityLinkRequired = errors.New("a user with the email of the identity exists")
+)
+
+// ExternalIdentity links a user to their account at an identity provider.
+type ExternalIdentity struct {
+	CoreModel
+	UserID   int64  `json:"user_id" gorm:"index;not null"`
+	User     *User  `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Provider string `json:"provider" gorm:"uniqueIndex:idx_identity_provider_subject;not null"`
+	Subject  string `json:"-" gorm:"uniqueIndex:idx_identity_provider_subject;not null"`
+	Email    string `json:"email" gorm:"not null"`
+}
+
+// OAuthState is kept between starting a login at a provider and the callback,
+// it can only be used once.
+type OAuthState struct {
+	CoreModel
+	Hash         []byte    `json:"-" gorm:"uniqueIndex;not null"`
+	Provider     string    `json:"-" gorm:"not null"`
+	Nonce        string    `json:"-" gorm:"not null"`
+	CodeVerifier string    `json:"-" gorm:"not null"`
+	Expiry       time.Time `json:"-" gorm:"index;not null"`
+}
+
+type IdentityModel struct {
+	DB *gorm.DB
+}
+
+// NewState() stores the nonce and PKCE verifier of a new login.
+func (m IdentityModel) NewState(provider, state, nonce, codeVerifier string, ttl time.Duration) error {
+	// expired states of abandoned logins are removed on the way.
+	if err := m.DB.Where("expiry <= ?", time.Now()).Delete(&OAuthState{}).Error; err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256([]byte(state))
+	return m.DB.Create(&OAuthState{
+		Hash:         hash[:],
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		Expiry:       time.Now().Add(ttl),
+	}).Error
+}
+
+// ConsumeState() returns the stored state and deletes it.
+func (m IdentityModel) ConsumeState(provider, state string) (*OAuthState, error) {
+	hash := sha256.Sum256([]byte(state))
+
+	tx := m.DB.Begin()
+
+	var s OAuthState
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("hash=? and provider=? and expiry > ?", hash[:], provider, time.Now()).
+		First(&s).Error
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if err := tx.Delete(&s).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetOrCreateUser() returns the user linked to the identity, or creates a new
+// user for an unknown identity. Emails are only trusted when the provider
+// verified them. ErrIdentityLinkRequired means a user with the same email
+// exists, the identity is only linked to them through Link() once they are
+// logged in, so whoever controls the provider account can not take it over.
+func (m IdentityModel) GetOrCreateUser(provider string, identity *oidc.Identity, roleID int64) (*User, error) {
+	tx := m.DB.Begin()
+	user, err := getOrCreateUserTx(tx, provider, identity, roleID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func getOrCreateUserTx(tx *gorm.DB, provider string, identity *oidc.Identity, roleID int64) (*User, error) {
+	var linked ExternalIdentity
+	err := tx.Where("provider=? and subject=?", provider, identity.Subject).First(&linked).Error
+	switch {
+	case err == nil:
+		return getUserTx(tx, linked.UserID)
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	if !identity.EmailVerified || identity.Email == "" {
+		return nil, ErrUnverifiedEmail
+	}
+
+	var existing int64
+	if err := tx.Model(&User{}).Where("email=?", identity.Email).Count(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return nil, ErrIdentityLinkRequired
+	}
+
+	user := User{
+		FirstName:   identity.FirstName,
+		LastName:    identity.LastName,
+		Email:       identity.Email,
+		IsActivated: true,
+		RoleID:      roleID,
+	}
+	// the user can not log in with a password until they reset it.
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return nil, err
+	}
+	if err := user.SetPassword(string(password)); err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&user).Error; err != nil {
+		if IsDuplicateRecord(err) {
+			return nil, ErrIdentityLinkRequired
+		}
+		return nil, err
+	}
+
+	link := ExternalIdentity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+	if err := tx.Create(&link).Error; err != nil {
+		return nil, err
+	}
+
+	return getUserTx(tx, user.ID)
+}
+
+// Link() links the identity to the logged in user, ErrDuplicateRecord means
+// it is linked to another user already.
+func (m IdentityModel) Link(user *User, provider string, identity *oidc.Identity) error {
+	var linked ExternalIdentity
+	err := m.DB.Where("provider=? and subject=?", provider, identity.Subject).First(&linked).Error
+	switch {
+	case err == nil && linked.UserID == user.ID:
+		return nil
+	case err == nil:
+		return ErrDuplicateRecord
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+
+	link := ExternalIdentity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+	if err := m.DB.Create(&link).Error; err != nil {
+		switch {
+		case IsDuplicateRecord(err):
+			return ErrDuplicateRecord
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func getUserTx(tx *gorm.DB, id int64) (*User, error) {
+	var user User
+	if err := tx.Preload("Role").Where("id=?", id).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (m IdentityModel) GetAllForUser(userID int64) ([]ExternalIdentity, error) {
+	var identities []ExternalIdentity
+	if err := m.DB.Where("user_id=?", userID).Order("id").Find(&identities).Error; err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
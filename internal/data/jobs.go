@@ -0,0 +1,208 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Job statuses, succeeded jobs are deleted.
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDead    = "dead"
+)
+
+var JobStatuses = []string{JobPending, JobRunning, JobDead}
+
+var ErrJobNotDead = errors.New("only dead jobs can be retried")
+
+// Job is a unit of work for the job worker. Payload is the JSON encoded
+// argument of the job, it is hidden from the API since it holds user data
+// like email addresses. It must never hold secrets, jobs create the codes
+// they send themselves.
+type Job struct {
+	CoreModel
+	Kind        string     `json:"kind" gorm:"index;not null"`
+	Payload     []byte     `json:"-" gorm:"not null"`
+	Status      string     `json:"status" gorm:"index:idx_jobs_status_run_at;not null;default:'pending'"`
+	RunAt       time.Time  `json:"run_at" gorm:"index:idx_jobs_status_run_at;not null"`
+	Attempts    int        `json:"attempts" gorm:"not null;default:0"`
+	MaxAttempts int        `json:"max_attempts" gorm:"not null"`
+	LockedAt    *time.Time `json:"locked_at"`
+	LastError   string     `json:"last_error,omitempty" gorm:"not null;default:''"`
+}
+
+type JobModel struct {
+	DB *gorm.DB
+}
+
+// Enqueue() stores a job which runs as soon as a worker is free.
+func (m JobModel) Enqueue(kind string, payload interface{}, maxAttempts int) (*Job, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		Kind:        kind,
+		Payload:     b,
+		Status:      JobPending,
+		RunAt:       time.Now(),
+		MaxAttempts: maxAttempts,
+	}
+	if err := m.DB.Create(job).Error; err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Claim() locks the next due job for the worker, rows locked by other workers
+// are skipped so workers never wait on each other. ErrRecordNotFound means
+// there is nothing to do.
+func (m JobModel) Claim() (*Job, error) {
+	tx := m.DB.Begin()
+
+	var job Job
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status=? and run_at <= ?", JobPending, time.Now()).
+		Order("run_at").
+		First(&job).Error
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	job.Status = JobRunning
+	job.Attempts++
+	job.LockedAt = &now
+	err = tx.Model(&job).Updates(map[string]interface{}{
+		"status":    job.Status,
+		"attempts":  job.Attempts,
+		"locked_at": job.LockedAt,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Complete() deletes the succeeded job.
+func (m JobModel) Complete(job *Job) error {
+	return m.DB.Delete(job).Error
+}
+
+// Fail() schedules the job again at retryAt, or marks it as dead once
+// it used up its attempts.
+func (m JobModel) Fail(job *Job, cause error, retryAt time.Time) error {
+	job.Status = JobPending
+	job.RunAt = retryAt
+	if job.Attempts >= job.MaxAttempts {
+		job.Status = JobDead
+	}
+	job.LockedAt = nil
+	job.LastError = cause.Error()
+
+	return m.DB.Model(job).Updates(map[string]interface{}{
+		"status":     job.Status,
+		"run_at":     job.RunAt,
+		"locked_at":  nil,
+		"last_error": job.LastError,
+	}).Error
+}
+
+// RequeueStale() puts jobs back whose worker did not finish them in time,
+// e.g. because the process crashed. It returns the number of jobs.
+func (m JobModel) RequeueStale(timeout time.Duration) (int64, error) {
+	res := m.DB.Exec(`
+		UPDATE jobs
+		SET status = CASE WHEN attempts >= max_attempts THEN ? ELSE ? END,
+			locked_at = NULL,
+			last_error = 'worker did not finish the job in time',
+			updated_at = ?
+		WHERE status = ? and locked_at < ?`,
+		JobDead, JobPending, time.Now(), JobRunning, time.Now().Add(-timeout))
+	return res.RowsAffected, res.Error
+}
+
+// DeleteDead() deletes the jobs which died before t, it returns the number of jobs.
+func (m JobModel) DeleteDead(t time.Time) (int64, error) {
+	res := m.DB.Where("status=? and updated_at < ?", JobDead, t).Delete(&Job{})
+	return res.RowsAffected, res.Error
+}
+
+// Retry() runs a dead job again with fresh attempts.
+func (m JobModel) Retry(job *Job) error {
+	now := time.Now()
+	res := m.DB.Model(job).Where("status=?", JobDead).Updates(map[string]interface{}{
+		"status":   JobPending,
+		"run_at":   now,
+		"attempts": 0,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrJobNotDead
+	}
+
+	job.Status = JobPending
+	job.RunAt = now
+	job.Attempts = 0
+	return nil
+}
+
+func (m JobModel) GetByID(id int64) (*Job, error) {
+	var job Job
+	if err := m.DB.Where("id=?", id).First(&job).Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &job, nil
+}
+
+// GetAll() returns the jobs in the order they were enqueued, status and kind are optional filters.
+func (m JobModel) GetAll(p *Paginate, status, kind string) ([]Job, Metadata, error) {
+	filter := func(db *gorm.DB) *gorm.DB {
+		if status != "" {
+			db = db.Where("status=?", status)
+		}
+		if kind != "" {
+			db = db.Where("kind=?", kind)
+		}
+		return db
+	}
+
+	var jobs []Job
+	err := m.DB.Scopes(filter).Order("id").Scopes(p.PaginatedResults).Find(&jobs).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	p.Arrange(jobs)
+
+	var total int64
+	m.DB.Model(&Job{}).Scopes(filter).Count(&total)
+	metadata := CalculateMetadata(p, int(total))
+	if len(jobs) > 0 {
+		metadata.SetCursors(p, jobs[0].ID, jobs[len(jobs)-1].ID, len(jobs))
+	}
+	return jobs, metadata, nil
+}
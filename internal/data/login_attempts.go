@@ -0,0 +1,109 @@
+package data
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginAttempt counts the failed logins of an email address. It is keyed by
+// email, not by user, so unknown addresses are locked out the same way and
+// responses do not reveal which accounts exist.
+type LoginAttempt struct {
+	CoreModel
+	Email        string     `json:"email" gorm:"uniqueIndex;not null"`
+	Failures     int        `json:"failures" gorm:"not null;default:0"`
+	LastFailedAt *time.Time `json:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until"`
+}
+
+func (a *LoginAttempt) IsLocked() bool {
+	return a.LockedUntil != nil && a.LockedUntil.After(time.Now())
+}
+
+// LockoutPolicy locks an email after MaxAttempts failures, every failure
+// after that doubles the lockout duration, up to MaxLockout.
+// Failures older than Window are forgotten.
+type LockoutPolicy struct {
+	MaxAttempts int
+	Lockout     time.Duration
+	MaxLockout  time.Duration
+	Window      time.Duration
+}
+
+func (p LockoutPolicy) duration(failures int) time.Duration {
+	if failures < p.MaxAttempts {
+		return 0
+	}
+	d := p.Lockout
+	for i := p.MaxAttempts; i < failures && d < p.MaxLockout; i++ {
+		d *= 2
+	}
+	if d > p.MaxLockout {
+		d = p.MaxLockout
+	}
+	return d
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+type LoginAttemptModel struct {
+	DB *gorm.DB
+}
+
+// Get() returns the attempts of the email, a zero LoginAttempt when there is none.
+func (m LoginAttemptModel) Get(email string) (*LoginAttempt, error) {
+	var attempts []LoginAttempt
+	if err := m.DB.Where("email=?", normalizeEmail(email)).Limit(1).Find(&attempts).Error; err != nil {
+		return nil, err
+	}
+	if len(attempts) == 0 {
+		return &LoginAttempt{Email: normalizeEmail(email)}, nil
+	}
+	return &attempts[0], nil
+}
+
+// Fail() records a failed login and locks the email when the policy says so,
+// locked tells if this failure started a new lockout.
+func (m LoginAttemptModel) Fail(email string, policy LockoutPolicy) (attempt *LoginAttempt, locked bool, err error) {
+	now := time.Now()
+	attempt = &LoginAttempt{Email: normalizeEmail(email), Failures: 1, LastFailedAt: &now}
+
+	err = m.DB.Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "email"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failures": gorm.Expr("CASE WHEN login_attempts.last_failed_at < ? THEN 1 ELSE login_attempts.failures + 1 END",
+					now.Add(-policy.Window)),
+				"last_failed_at": now,
+				"updated_at":     now,
+			}),
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "id"}, {Name: "failures"}, {Name: "locked_until"}}},
+	).Create(attempt).Error
+	if err != nil {
+		return nil, false, err
+	}
+
+	d := policy.duration(attempt.Failures)
+	if d == 0 {
+		return attempt, false, nil
+	}
+
+	until := now.Add(d)
+	if err := m.DB.Model(attempt).UpdateColumn("locked_until", until).Error; err != nil {
+		return nil, false, err
+	}
+	attempt.LockedUntil = &until
+	return attempt, true, nil
+}
+
+// Reset() forgets the failures of the email, after a successful login
+// or when an admin unlocks the account.
+func (m LoginAttemptModel) Reset(email string) error {
+	return m.DB.Where("email=?", normalizeEmail(email)).Delete(&LoginAttempt{}).Error
+}
@@ -20,27 +20,47 @@ type CoreModel struct {
 }
 
 type Models struct {
-	Users      UserModel
-	Tokens     TokenModel
-	Roles      RoleModel
-	Products   ProductModel
-	Categories CategoryModel
-	Reviews    ReviewModel
-	Ratings    RatingModel
-	Orders     OrderModel
-	OrderItems OrderItemModel
+	Users           UserModel
+	Tokens          TokenModel
+	Roles           RoleModel
+	Products        ProductModel
+	Categories      CategoryModel
+	Reviews         ReviewModel
+	Ratings         RatingModel
+	Orders          OrderModel
+	OrderItems      OrderItemModel
+	IdempotencyKeys IdempotencyKeyModel
+	Carts           CartModel
+	Variants        ProductVariantModel
+	TwoFactor       TwoFactorModel
+	Permissions     PermissionModel
+	LoginAttempts   LoginAttemptModel
+	Audit           AuditModel
+	APIKeys         APIKeyModel
+	Identities      IdentityModel
+	Jobs            JobModel
 }
 
 func NewModels(db *gorm.DB) Models {
 	return Models{
-		Users:      UserModel{DB: db},
-		Tokens:     TokenModel{DB: db},
-		Roles:      RoleModel{DB: db},
-		Products:   ProductModel{DB: db},
-		Categories: CategoryModel{DB: db},
-		Reviews:    ReviewModel{DB: db},
-		Ratings:    RatingModel{DB: db},
-		Orders:     OrderModel{DB: db},
-		OrderItems: OrderItemModel{DB: db},
+		Users:           UserModel{DB: db},
+		Tokens:          TokenModel{DB: db},
+		Roles:           RoleModel{DB: db},
+		Products:        ProductModel{DB: db},
+		Categories:      CategoryModel{DB: db},
+		Reviews:         ReviewModel{DB: db},
+		Ratings:         RatingModel{DB: db},
+		Orders:          OrderModel{DB: db},
+		OrderItems:      OrderItemModel{DB: db},
+		IdempotencyKeys: IdempotencyKeyModel{DB: db},
+		Carts:           CartModel{DB: db},
+		Variants:        ProductVariantModel{DB: db},
+		TwoFactor:       TwoFactorModel{DB: db},
+		Permissions:     PermissionModel{DB: db},
+		LoginAttempts:   LoginAttemptModel{DB: db},
+		Audit:           AuditModel{DB: db},
+		APIKeys:         APIKeyModel{DB: db},
+		Identities:      IdentityModel{DB: db},
+		Jobs:            JobModel{DB: db},
 	}
 }
@@ -0,0 +1,43 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+const DefaultCurrency = "TRY"
+
+// Money is an amount in minor units (kuruş, cents...) with its ISO 4217 currency code,
+// embed it with a prefix: gorm:"embedded;embeddedPrefix:price_"
+type Money struct {
+	Amount   int64  `json:"amount" gorm:"not null;default:0"`
+	Currency string `json:"currency" gorm:"type:varchar(3);not null;default:TRY"`
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+func (m Money) Mul(n int64) Money {
+	return Money{Amount: m.Amount * n, Currency: m.Currency}
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// String() formats the amount with two decimals, e.g. "19.99 TRY"
+func (m Money) String() string {
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d %s", sign, amount/100, amount%100, m.Currency)
+}
@@ -0,0 +1,191 @@
+package data
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+const (
+	OrderStatusPending    = "pending"
+	OrderStatusPaid       = "paid"
+	OrderStatusProcessing = "processing"
+	OrderStatusShipped    = "shipped"
+	OrderStatusDelivered  = "delivered"
+	OrderStatusCancelled  = "cancelled"
+	OrderStatusRefunded   = "refunded"
+)
+
+// orderTransitions holds the legal moves of the order lifecycle,
+// statuses without an entry are final.
+var orderTransitions = map[string][]string{
+	OrderStatusPending:    {OrderStatusPaid, OrderStatusCancelled},
+	OrderStatusPaid:       {OrderStatusProcessing, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusProcessing: {OrderStatusShipped, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusShipped:    {OrderStatusDelivered},
+	OrderStatusDelivered:  {OrderStatusRefunded},
+}
+
+var OrderStatuses = []string{
+	OrderStatusPending,
+	OrderStatusPaid,
+	OrderStatusProcessing,
+	OrderStatusShipped,
+	OrderStatusDelivered,
+	OrderStatusCancelled,
+	OrderStatusRefunded,
+}
+
+// CanTransition() reports whether an order can move from one status to another.
+func CanTransition(from, to string) bool {
+	return In(orderTransitions[from], to)
+}
+
+type OrderTransition struct {
+	CoreModel
+	OrderID    int64  `json:"order_id" gorm:"index;not null"`
+	FromStatus string `json:"from_status" gorm:"not null"`
+	ToStatus   string `json:"to_status" gorm:"not null"`
+	UserID     int64  `json:"user_id" gorm:"not null"` // zero for API keys
+	Note       string `json:"note" gorm:"not null"`
+}
+
+// Transition() moves the order to the given status and records the move,
+// userID is the user who made the change.
+func (m OrderModel) Transition(order *Order, to string, userID int64, note string) error {
+	tx := m.DB.Begin()
+
+	// lock the order row, so concurrent transitions can not skip a check.
+	var current Order
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id=?", order.ID).First(&current).Error
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if err := transitionTx(tx, &current, to, userID, note); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	order.Status = current.Status
+	order.PaidAt = current.PaidAt
+	order.DeliveredAt = current.DeliveredAt
+	return nil
+}
+
+// transitionTx() is the transactional part of Transition(),
+// the order row must be locked by the caller.
+func transitionTx(tx *gorm.DB, order *Order, to string, userID int64, note string) error {
+	if !CanTransition(order.Status, to) {
+		return ErrInvalidTransition
+	}
+
+	transition := OrderTransition{
+		OrderID:    order.ID,
+		FromStatus: order.Status,
+		ToStatus:   to,
+		UserID:     userID,
+		Note:       note,
+	}
+
+	updates := map[string]interface{}{"status": to}
+	switch to {
+	case OrderStatusPaid:
+		order.PaidAt = time.Now()
+		updates["paid_at"] = order.PaidAt
+	case OrderStatusDelivered:
+		order.DeliveredAt = time.Now()
+		updates["delivered_at"] = order.DeliveredAt
+	case OrderStatusCancelled:
+		if err := restockTx(tx, order.ID); err != nil {
+			return err
+		}
+		order.CancelReason = note
+		updates["cancel_reason"] = note
+	}
+	order.Status = to
+
+	if err := tx.Model(&Order{}).Where("id=?", order.ID).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	return tx.Create(&transition).Error
+}
+
+// restockTx() gives the quantities of the order's items back to their products
+// (or variants), rows are updated in the same order CreateOrder() locks them.
+func restockTx(tx *gorm.DB, orderID int64) error {
+	var items []OrderItem
+	if err := tx.Where("order_id=?", orderID).Order("product_id, variant_id").Find(&items).Error; err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if item.VariantID != nil {
+			continue
+		}
+		err := tx.Model(&Product{}).
+			Where("id=?", item.ProductID).
+			UpdateColumn("count", gorm.Expr("count + ?", item.Quantity)).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return variantIDOf(items[i]) < variantIDOf(items[j])
+	})
+	for _, item := range items {
+		if item.VariantID == nil {
+			continue
+		}
+		err := tx.Model(&ProductVariant{}).
+			Where("id=?", *item.VariantID).
+			UpdateColumn("count", gorm.Expr("count + ?", item.Quantity)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func variantIDOf(item OrderItem) int64 {
+	if item.VariantID == nil {
+		return 0
+	}
+	return *item.VariantID
+}
+
+// Cancel() cancels the order and returns its items to stock,
+// reason is stored on the order and on the transition record.
+func (m OrderModel) Cancel(order *Order, userID int64, reason string) error {
+	if err := m.Transition(order, OrderStatusCancelled, userID, reason); err != nil {
+		return err
+	}
+	order.CancelReason = reason
+	return nil
+}
+
+func (m OrderModel) GetTransitions(orderID int64) ([]OrderTransition, error) {
+	var transitions []OrderTransition
+	err := m.DB.Where("order_id=?", orderID).Order("created_at").Find(&transitions).Error
+	if err != nil {
+		return nil, err
+	}
+	return transitions, nil
+}
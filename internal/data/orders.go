@@ -3,33 +3,41 @@ package data
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/kubil6y/dukkan-go/internal/validator"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Order struct {
 	CoreModel
-	UserID        int64       `json:"user_id" gorm:"not null"`
-	User          *User       `json:"user,omitempty"`
-	PaymentMethod string      `json:"payment_method" gorm:"not null"`
-	IsPaid        bool        `json:"is_paid" gorm:"not null"`
-	IsDelivered   bool        `json:"is_delivered" gorm:"not null"`
-	PaidAt        time.Time   `json:"paid_at" gorm:"not null"`
-	TotalPrice    float64     `json:"total_price" gorm:"not null"`
-	DeliveredAt   time.Time   `json:"delivered_at" gorm:"not null"`
-	OrderItems    []OrderItem `json:"order_items" gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE"`
+	UserID        int64             `json:"user_id" gorm:"not null"`
+	User          *User             `json:"user,omitempty"`
+	PaymentMethod string            `json:"payment_method" gorm:"not null"`
+	Status        string            `json:"status" gorm:"index;default:pending;not null"`
+	PaidAt        time.Time         `json:"paid_at" gorm:"not null"`
+	TotalPrice    Money             `json:"total_price" gorm:"embedded;embeddedPrefix:total_price_"`
+	DeliveredAt   time.Time         `json:"delivered_at" gorm:"not null"`
+	CancelReason  string            `json:"cancel_reason,omitempty"`
+	OrderItems    []OrderItem       `json:"order_items" gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE"`
+	Transitions   []OrderTransition `json:"transitions,omitempty" gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE"`
 }
 
 type OrderItem struct {
 	CoreModel
-	OrderID   int64    `json:"order_id" gorm:"not null"`
-	Order     *Order   `json:"order,omitempty"`
-	ProductID int64    `json:"product_id" gorm:"not null"`
-	Product   *Product `json:"product,omitempty"`
-	Quantity  int64    `json:"quantity" gorm:"not null"`
+	OrderID   int64           `json:"order_id" gorm:"not null"`
+	Order     *Order          `json:"order,omitempty"`
+	ProductID int64           `json:"product_id" gorm:"not null"`
+	Product   *Product        `json:"product,omitempty"`
+	VariantID *int64          `json:"variant_id,omitempty"`
+	Variant   *ProductVariant `json:"variant,omitempty" gorm:"constraint:OnDelete:SET NULL"`
+	SKU       string          `json:"sku,omitempty"`
+	Quantity  int64           `json:"quantity" gorm:"not null"`
+	UnitPrice Money           `json:"unit_price" gorm:"embedded;embeddedPrefix:unit_price_"`
+	LineTotal Money           `json:"line_total" gorm:"embedded;embeddedPrefix:line_total_"`
 }
 
 type OrderModel struct {
@@ -42,7 +50,7 @@ type OrderItemModel struct {
 
 func (m OrderModel) GetByID(id int64) (*Order, error) {
 	var order Order
-	err := m.DB.Where("id=?", id).Preload("OrderItems.Product").First(&order).Error
+	err := m.DB.Where("id=?", id).Preload("OrderItems.Product").Preload("OrderItems.Variant").First(&order).Error
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
@@ -56,27 +64,35 @@ func (m OrderModel) GetByID(id int64) (*Order, error) {
 
 func (m OrderModel) GetAllOrders(p *Paginate) ([]Order, Metadata, error) {
 	var orders []Order
-	err := m.DB.Scopes(p.PaginatedResults).Preload("OrderItems.Product").Find(&orders).Error
+	err := m.DB.Order("id").Scopes(p.PaginatedResults).Preload("OrderItems.Product").Preload("OrderItems.Variant").Find(&orders).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
+	p.Arrange(orders)
 
 	var total int64
 	m.DB.Model(&Order{}).Count(&total)
 	metadata := CalculateMetadata(p, int(total))
+	if len(orders) > 0 {
+		metadata.SetCursors(p, orders[0].ID, orders[len(orders)-1].ID, len(orders))
+	}
 	return orders, metadata, nil
 }
 
 func (m OrderModel) GetAllOrdersByUserID(p *Paginate, userID int64) ([]Order, Metadata, error) {
 	var orders []Order
-	err := m.DB.Where("user_id=?", userID).Scopes(p.PaginatedResults).Preload("OrderItems.Product").Find(&orders).Error
+	err := m.DB.Where("user_id=?", userID).Order("id").Scopes(p.PaginatedResults).Preload("OrderItems.Product").Preload("OrderItems.Variant").Find(&orders).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
+	p.Arrange(orders)
 
 	var total int64
 	m.DB.Model(&Order{}).Where("user_id=?", userID).Count(&total)
 	metadata := CalculateMetadata(p, int(total))
+	if len(orders) > 0 {
+		metadata.SetCursors(p, orders[0].ID, orders[len(orders)-1].ID, len(orders))
+	}
 	return orders, metadata, nil
 }
 
@@ -88,12 +104,42 @@ func (m OrderModel) Update(o *Order) error {
 	return m.DB.Updates(o).Error
 }
 
+// Delete() deletes the order, orders which could still be cancelled hold
+// their stock, it is given back in the same transaction.
 func (m OrderModel) Delete(o *Order) error {
-	return m.DB.Delete(o).Error
+	tx := m.DB.Begin()
+
+	var current Order
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id=?", o.ID).First(&current).Error
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if CanTransition(current.Status, OrderStatusCancelled) {
+		if err := restockTx(tx, current.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Delete(&current).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
 }
 
+// VariantID is zero for products without variants.
 type OrderItemDTO struct {
 	ProductID int64 `json:"product_id"`
+	VariantID int64 `json:"variant_id"`
 	Quantity  int64 `json:"quantity"`
 }
 
@@ -106,46 +152,168 @@ func (d *CreateOrderDTO) Validate(v *validator.Validator) {
 	v.Check(d.PaymentMethod != "", "payment_method", "must be provided")
 	v.Check(In([]string{"cash", "credit"}, strings.ToLower(strings.Trim(d.PaymentMethod, " "))), "payment_method", "must be cash or credit")
 	v.Check(len(d.OrderItems) > 0, "order_items", "must be provided")
+
+	for _, item := range d.OrderItems {
+		v.Check(item.ProductID > 0, "product_id", "invalid product id value")
+		v.Check(item.VariantID >= 0, "variant_id", "invalid variant id value")
+		v.Check(item.Quantity > 0, "quantity", "must be greater than zero")
+	}
+}
+
+// mergeOrderItems() sums the quantities of repeated products (and variants),
+// results are sorted by ids so rows are always locked in the same order.
+func mergeOrderItems(items []OrderItemDTO) []OrderItemDTO {
+	type key struct{ productID, variantID int64 }
+
+	quantities := make(map[key]int64)
+	for _, item := range items {
+		quantities[key{item.ProductID, item.VariantID}] += item.Quantity
+	}
+
+	merged := make([]OrderItemDTO, 0, len(quantities))
+	for k, quantity := range quantities {
+		merged = append(merged, OrderItemDTO{ProductID: k.productID, VariantID: k.variantID, Quantity: quantity})
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].ProductID != merged[j].ProductID {
+			return merged[i].ProductID < merged[j].ProductID
+		}
+		return merged[i].VariantID < merged[j].VariantID
+	})
+	return merged
 }
 
 func (m OrderModel) CreateOrder(userID int64, dto CreateOrderDTO) (*Order, error) {
+	tx := m.DB.Begin()
+	order, err := createOrderTx(tx, userID, dto)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// createOrderTx() is the transactional part of CreateOrder(),
+// the caller is responsible for rolling back on errors.
+func createOrderTx(tx *gorm.DB, userID int64, dto CreateOrderDTO) (*Order, error) {
 	var order Order
 	order.UserID = userID
 	order.PaymentMethod = dto.PaymentMethod
+	order.Status = OrderStatusPending
 
-	tx := m.DB.Begin()
-	err := tx.Create(&order).Error
+	items := mergeOrderItems(dto.OrderItems)
+	var productIDs, variantIDs []int64
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+		if item.VariantID != 0 {
+			variantIDs = append(variantIDs, item.VariantID)
+		}
+	}
+	sort.Slice(variantIDs, func(i, j int) bool { return variantIDs[i] < variantIDs[j] })
+
+	// lock every product and variant of the order in id order, concurrent orders
+	// for the same products wait here instead of overselling (and can't deadlock).
+	var products []Product
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ?", productIDs).
+		Order("id").
+		Find(&products).Error
 	if err != nil {
-		tx.Rollback()
 		return nil, err
 	}
 
-	var total float64
+	productsByID := make(map[int64]Product, len(products))
+	for _, product := range products {
+		productsByID[product.ID] = product
+	}
 
-	for _, item := range dto.OrderItems {
-		var product Product
-		err := m.DB.Where("id=?", item.ProductID).First(&product).Error
+	variantsByID := make(map[int64]ProductVariant)
+	if len(variantIDs) > 0 {
+		var variants []ProductVariant
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ?", variantIDs).
+			Order("id").
+			Find(&variants).Error
 		if err != nil {
-			tx.Rollback()
-			return nil, errors.New(fmt.Sprintf("product_id: %d does not exist\n", item.ProductID))
+			return nil, err
 		}
-
-		// check product's stock and save new stock count
-		newProductCount := product.Count - item.Quantity
-		if newProductCount >= 0 {
-			product.Count = newProductCount
-			tx.Save(&product)
-		} else {
-			tx.Rollback()
-			return nil, ErrOutOfStock
+		for _, variant := range variants {
+			variantsByID[variant.ID] = variant
 		}
+	}
+
+	// products with variants can only be ordered through one of their variants.
+	var withVariants []int64
+	err = tx.Model(&ProductVariant{}).Where("product_id IN ?", productIDs).Distinct().Pluck("product_id", &withVariants).Error
+	if err != nil {
+		return nil, err
+	}
+	hasVariants := make(map[int64]bool, len(withVariants))
+	for _, id := range withVariants {
+		hasVariants[id] = true
+	}
+
+	err = tx.Create(&order).Error
+	if err != nil {
+		return nil, err
+	}
 
-		total += (product.Price * float64(item.Quantity))
+	var total Money
+
+	for i, item := range items {
+		product, ok := productsByID[item.ProductID]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("product_id: %d does not exist\n", item.ProductID))
+		}
 
 		orderItem := OrderItem{
 			OrderID:   order.ID,
 			ProductID: product.ID,
 			Quantity:  item.Quantity,
+			UnitPrice: product.Price,
+		}
+
+		// conditional decrement, the stock can never go below zero.
+		var result *gorm.DB
+		if item.VariantID != 0 {
+			variant, ok := variantsByID[item.VariantID]
+			if !ok || variant.ProductID != product.ID {
+				return nil, ErrInvalidVariant
+			}
+			result = tx.Model(&ProductVariant{}).
+				Where("id=? and count >= ?", variant.ID, item.Quantity).
+				UpdateColumn("count", gorm.Expr("count - ?", item.Quantity))
+			orderItem.VariantID = &variant.ID
+			orderItem.SKU = variant.SKU
+			orderItem.UnitPrice = variant.PriceFor(&product)
+		} else {
+			if hasVariants[product.ID] {
+				return nil, ErrVariantRequired
+			}
+			result = tx.Model(&Product{}).
+				Where("id=? and count >= ?", product.ID, item.Quantity).
+				UpdateColumn("count", gorm.Expr("count - ?", item.Quantity))
+		}
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil, ErrOutOfStock
+		}
+
+		// prices are copied to the order item, later product price changes
+		// must not change the value of this order.
+		orderItem.LineTotal = orderItem.UnitPrice.Mul(item.Quantity)
+		if i == 0 {
+			total = orderItem.LineTotal
+		} else if total, err = total.Add(orderItem.LineTotal); err != nil {
+			return nil, err
 		}
 
 		order.OrderItems = append(order.OrderItems, orderItem)
@@ -155,12 +323,9 @@ func (m OrderModel) CreateOrder(userID int64, dto CreateOrderDTO) (*Order, error
 
 	err = tx.Save(&order).Error
 	if err != nil {
-		tx.Rollback()
 		return nil, err
 	}
 
-	tx.Commit()
-
 	return &order, nil
 }
 
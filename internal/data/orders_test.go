@@ -0,0 +1,213 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubil6y/dukkan-go/internal/migrations"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB() connects to the database of DUKKAN_TEST_DB_DSN and migrates
+// it, tests which need a database are skipped when it is not set.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("DUKKAN_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("DUKKAN_TEST_DB_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	migrator, err := migrations.New(sqlDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCreateOrderIsNeverOversold(t *testing.T) {
+	db := newTestDB(t)
+
+	const stock = 5
+	const orders = 40
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	role := Role{Name: "oversell-" + suffix}
+	if err := db.Create(&role).Error; err != nil {
+		t.Fatal(err)
+	}
+	user := User{FirstName: "test", LastName: "test", Email: suffix + "@example.com", Password: []byte("-"), RoleID: role.ID}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	category := Category{Name: "oversell-" + suffix, Slug: "oversell-" + suffix}
+	if err := db.Create(&category).Error; err != nil {
+		t.Fatal(err)
+	}
+	product := Product{
+		Name:       "oversell",
+		Slug:       "oversell-" + suffix,
+		Price:      NewMoney(1000, DefaultCurrency),
+		Count:      stock,
+		CategoryID: category.ID,
+	}
+	if err := db.Create(&product).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Where("user_id=?", user.ID).Delete(&Order{})
+		db.Delete(&product)
+		db.Delete(&category)
+		db.Delete(&user)
+		db.Delete(&role)
+	})
+
+	m := OrderModel{DB: db}
+	dto := CreateOrderDTO{
+		PaymentMethod: "cash",
+		OrderItems:    []OrderItemDTO{{ProductID: product.ID, Quantity: 1}},
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded, outOfStock := 0, 0
+	start := make(chan struct{})
+
+	for i := 0; i < orders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			_, err := m.CreateOrder(user.ID, dto)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, ErrOutOfStock):
+				outOfStock++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if succeeded != stock {
+		t.Errorf("succeeded orders = %d, want %d", succeeded, stock)
+	}
+	if outOfStock != orders-stock {
+		t.Errorf("out of stock orders = %d, want %d", outOfStock, orders-stock)
+	}
+
+	var count int64
+	if err := db.Model(&Product{}).Where("id=?", product.ID).Pluck("count", &count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("final count = %d, want 0", count)
+	}
+}
+
+func TestDeleteOrderRestocks(t *testing.T) {
+	db := newTestDB(t)
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	role := Role{Name: "delete-order-" + suffix}
+	if err := db.Create(&role).Error; err != nil {
+		t.Fatal(err)
+	}
+	user := User{FirstName: "test", LastName: "test", Email: suffix + "@example.com", Password: []byte("-"), RoleID: role.ID}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	category := Category{Name: "delete-order-" + suffix, Slug: "delete-order-" + suffix}
+	if err := db.Create(&category).Error; err != nil {
+		t.Fatal(err)
+	}
+	product := Product{
+		Name:       "delete-order",
+		Slug:       "delete-order-" + suffix,
+		Price:      NewMoney(1000, DefaultCurrency),
+		Count:      10,
+		CategoryID: category.ID,
+	}
+	if err := db.Create(&product).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Where("user_id=?", user.ID).Delete(&Order{})
+		db.Delete(&product)
+		db.Delete(&category)
+		db.Delete(&user)
+		db.Delete(&role)
+	})
+
+	m := OrderModel{DB: db}
+	dto := CreateOrderDTO{
+		PaymentMethod: "cash",
+		OrderItems:    []OrderItemDTO{{ProductID: product.ID, Quantity: 3}},
+	}
+
+	count := func() int64 {
+		var count int64
+		if err := db.Model(&Product{}).Where("id=?", product.ID).Pluck("count", &count).Error; err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	// a pending order holds its stock, deleting it gives the stock back.
+	pending, err := m.CreateOrder(user.ID, dto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete(pending); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(); got != 10 {
+		t.Errorf("count after deleting a pending order = %d, want 10", got)
+	}
+
+	// a cancelled order was restocked already.
+	cancelled, err := m.CreateOrder(user.ID, dto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Cancel(cancelled, user.ID, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete(cancelled); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(); got != 10 {
+		t.Errorf("count after deleting a cancelled order = %d, want 10", got)
+	}
+
+	if err := m.Delete(cancelled); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Delete() of a deleted order = %v, want ErrRecordNotFound", err)
+	}
+}
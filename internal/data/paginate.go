@@ -3,15 +3,21 @@ package data
 import (
 	"math"
 	"net/http"
+	"reflect"
 	"strconv"
 
 	"github.com/kubil6y/dukkan-go/internal/validator"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// Paginate is page based by default, with ?after= or ?before= cursors
+// it switches to keyset pagination on the id column.
 type Paginate struct {
-	Limit int `json:"limit"`
-	Page  int `json:"page"`
+	Limit  int    `json:"limit"`
+	Page   int    `json:"page"`
+	After  *int64 `json:"-"`
+	Before *int64 `json:"-"`
 }
 
 func ValidatePaginate(p *Paginate, v *validator.Validator) {
@@ -19,28 +25,89 @@ func ValidatePaginate(p *Paginate, v *validator.Validator) {
 	v.Check(p.Limit > 0, "limit", "must be greater than zero")
 	v.Check(p.Page <= 100, "page", "must be a maximum of 100")
 	v.Check(p.Limit <= 25, "limit", "must be a maximum of 25")
+	v.Check(p.After == nil || p.Before == nil, "after", "can not be used together with before")
+	if p.IsCursor() {
+		v.Check(p.Page == 1, "page", "can not be used together with a cursor")
+	}
 }
 
 func NewPaginate(r *http.Request, v *validator.Validator, limitDefault, pageDefault int) *Paginate {
 	return &Paginate{
-		Limit: readInt(r, v, "limit", limitDefault),
-		Page:  readInt(r, v, "page", pageDefault),
+		Limit:  readInt(r, v, "limit", limitDefault),
+		Page:   readInt(r, v, "page", pageDefault),
+		After:  readCursor(r, v, "after"),
+		Before: readCursor(r, v, "before"),
 	}
 }
 
+func (p Paginate) IsCursor() bool {
+	return p.After != nil || p.Before != nil
+}
+
 // PaginatedResults is used when making db calls, example:
 // err := m.DB.Scopes(p.PaginatedResults).Find(&users).Error
+// with a cursor the results are ordered by id, call Arrange() on them afterwards.
 func (p Paginate) PaginatedResults(db *gorm.DB) *gorm.DB {
+	if p.IsCursor() {
+		return p.CursorResults("id")(db)
+	}
 	offset := (p.Page - 1) * p.Limit
 	return db.Offset(offset).Limit(p.Limit)
 }
 
+// CursorResults() is the keyset version of PaginatedResults, column is the
+// (table qualified if needed) id column. It replaces any previous ordering.
+func (p Paginate) CursorResults(column string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		order := clause.OrderByColumn{Column: clause.Column{Name: column, Raw: true}, Reorder: true}
+		switch {
+		case p.After != nil:
+			db = db.Where(column+" > ?", *p.After)
+		case p.Before != nil:
+			// rows before the cursor are fetched backwards, Arrange() flips them.
+			db = db.Where(column+" < ?", *p.Before)
+			order.Desc = true
+		}
+		return db.Order(order).Limit(p.Limit)
+	}
+}
+
+// Arrange() puts results of a ?before= query back in ascending order,
+// results must be a slice.
+func (p Paginate) Arrange(results interface{}) {
+	if p.Before == nil {
+		return
+	}
+	v := reflect.ValueOf(results)
+	swap := reflect.Swapper(results)
+	for i, j := 0, v.Len()-1; i < j; i, j = i+1, j-1 {
+		swap(i, j)
+	}
+}
+
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+}
+
+// SetCursors() adds the cursors of the neighbouring pages, for results ordered by id.
+// A full page is assumed to have a next page.
+func (md *Metadata) SetCursors(p *Paginate, firstID, lastID int64, count int) {
+	if count == 0 {
+		return
+	}
+
+	if count == p.Limit || p.Before != nil {
+		md.NextCursor = EncodeCursor(lastID)
+	}
+	if p.After != nil || (p.Before != nil && count == p.Limit) || (!p.IsCursor() && p.Page > 1) {
+		md.PrevCursor = EncodeCursor(firstID)
+	}
 }
 
 func CalculateMetadata(p *Paginate, total int) Metadata {
@@ -49,6 +116,14 @@ func CalculateMetadata(p *Paginate, total int) Metadata {
 		return Metadata{}
 	}
 
+	// pages have no meaning with cursors.
+	if p.IsCursor() {
+		return Metadata{
+			PageSize:     p.Limit,
+			TotalRecords: total,
+		}
+	}
+
 	return Metadata{
 		CurrentPage:  p.Page,
 		PageSize:     p.Limit,
@@ -58,6 +133,19 @@ func CalculateMetadata(p *Paginate, total int) Metadata {
 	}
 }
 
+func readCursor(r *http.Request, v *validator.Validator, key string) *int64 {
+	s := r.URL.Query().Get(key)
+	if s == "" {
+		return nil
+	}
+	id, err := DecodeCursor(s)
+	if err != nil {
+		v.AddError(key, "invalid cursor")
+		return nil
+	}
+	return &id
+}
+
 // NOTE code duplication for NewPaginate, cmd/api/helpers.go app.readInt
 func readInt(r *http.Request, v *validator.Validator, key string, defaultValue int) int {
 	qs := r.URL.Query()
@@ -0,0 +1,169 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	HashArgon2id = "argon2id"
+	HashBcrypt   = "bcrypt"
+)
+
+var HashAlgorithms = []string{HashArgon2id, HashBcrypt}
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// PasswordHashing is how new passwords are hashed. Hashes carry their own
+// algorithm and parameters, so older hashes keep working and are upgraded
+// with NeedsRehash() when their user logs in.
+//
+// argon2id hashes use the PHC format: $argon2id$v=19$m=65536,t=1,p=2$salt$key
+// bcrypt hashes use their own format: $2a$10$...
+type PasswordHashing struct {
+	Algorithm  string
+	BcryptCost int
+
+	// Memory is in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var passwordHashing = PasswordHashing{
+	Algorithm:   HashArgon2id,
+	BcryptCost:  bcrypt.DefaultCost,
+	Memory:      64 * 1024,
+	Iterations:  1,
+	Parallelism: 2,
+}
+
+// dummyPassword is compared when the email of a login is unknown,
+// so those requests take as long as the ones with a wrong password.
+var dummyPassword, _ = hashPassword("dukkan-dummy-password")
+
+func SetPasswordHashing(h PasswordHashing) error {
+	switch {
+	case h.Algorithm == HashBcrypt && (h.BcryptCost < bcrypt.MinCost || h.BcryptCost > bcrypt.MaxCost):
+		return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	case h.Algorithm == HashArgon2id && (h.Memory == 0 || h.Iterations == 0 || h.Parallelism == 0):
+		return errors.New("argon2id memory, iterations and parallelism must be positive")
+	case h.Algorithm != HashBcrypt && h.Algorithm != HashArgon2id:
+		return fmt.Errorf("unknown password hash algorithm %q", h.Algorithm)
+	}
+
+	passwordHashing = h
+	dummy, err := hashPassword("dukkan-dummy-password")
+	if err != nil {
+		return err
+	}
+	dummyPassword = dummy
+	return nil
+}
+
+func CompareDummyPassword(plain string) {
+	comparePassword(dummyPassword, plain)
+}
+
+func hashPassword(plain string) ([]byte, error) {
+	h := passwordHashing
+	if h.Algorithm == HashBcrypt {
+		return bcrypt.GenerateFromPassword([]byte(plain), h.BcryptCost)
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(plain), salt, h.Iterations, h.Memory, h.Parallelism, argon2KeyLength)
+
+	enc := base64.RawStdEncoding
+	return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		enc.EncodeToString(salt), enc.EncodeToString(key))), nil
+}
+
+func comparePassword(hash []byte, plain string) (bool, error) {
+	if !bytes.HasPrefix(hash, []byte("$argon2id$")) {
+		err := bcrypt.CompareHashAndPassword(hash, []byte(plain))
+		switch {
+		case err == nil:
+			return true, nil
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(plain), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func decodeArgon2id(hash []byte) (params PasswordHashing, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	params.Algorithm = HashArgon2id
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	enc := base64.RawStdEncoding
+	if salt, err = enc.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	if key, err = enc.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+	return params, salt, key, nil
+}
+
+// needsRehash() reports whether the hash was made with another algorithm or
+// other parameters than the current ones.
+func needsRehash(hash []byte) bool {
+	h := passwordHashing
+
+	if !bytes.HasPrefix(hash, []byte("$argon2id$")) {
+		if h.Algorithm != HashBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost(hash)
+		return err != nil || cost != h.BcryptCost
+	}
+
+	if h.Algorithm != HashArgon2id {
+		return true
+	}
+	params, _, key, err := decodeArgon2id(hash)
+	return err != nil ||
+		params.Memory != h.Memory ||
+		params.Iterations != h.Iterations ||
+		params.Parallelism != h.Parallelism ||
+		len(key) != argon2KeyLength
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Permission codes checked by the admin routes, they are created on startup.
+const (
+	PermissionUsersRead       = "users:read"
+	PermissionUsersWrite      = "users:write"
+	PermissionRolesRead       = "roles:read"
+	PermissionRolesWrite      = "roles:write"
+	PermissionOrdersRead      = "orders:read"
+	PermissionOrdersWrite     = "orders:write"
+	PermissionCategoriesRead  = "categories:read"
+	PermissionCategoriesWrite = "categories:write"
+	PermissionProductsWrite   = "products:write"
+	PermissionAuditRead       = "audit:read"
+	PermissionAPIKeysRead     = "api-keys:read"
+	PermissionAPIKeysWrite    = "api-keys:write"
+	PermissionJobsRead        = "jobs:read"
+	PermissionJobsWrite       = "jobs:write"
+)
+
+var PermissionCodes = []string{
+	PermissionUsersRead,
+	PermissionUsersWrite,
+	PermissionRolesRead,
+	PermissionRolesWrite,
+	PermissionOrdersRead,
+	PermissionOrdersWrite,
+	PermissionCategoriesRead,
+	PermissionCategoriesWrite,
+	PermissionProductsWrite,
+	PermissionAuditRead,
+	PermissionAPIKeysRead,
+	PermissionAPIKeysWrite,
+	PermissionJobsRead,
+	PermissionJobsWrite,
+}
+
+type Permission struct {
+	CoreModel
+	Code string `json:"code" gorm:"uniqueIndex;not null"`
+}
+
+type Permissions []Permission
+
+func (p Permissions) Include(code string) bool {
+	for _, permission := range p {
+		if permission.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission() reports whether the role was granted the permission.
+func (r *Role) HasPermission(code string) bool {
+	if r == nil {
+		return false
+	}
+	return r.Permissions.Include(code)
+}
+
+type PermissionModel struct {
+	DB *gorm.DB
+}
+
+// Sync() creates the missing permissions, the role with the given name
+// gets all of them so admins keep their access to every route.
+func (m PermissionModel) Sync(adminRole string) error {
+	permissions := make([]Permission, len(PermissionCodes))
+	for i, code := range PermissionCodes {
+		permissions[i] = Permission{Code: code}
+	}
+
+	err := m.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&permissions).Error
+	if err != nil {
+		return err
+	}
+
+	return m.DB.Exec(`INSERT INTO role_permissions (role_id, permission_id)
+		SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = ?
+		ON CONFLICT DO NOTHING`, adminRole).Error
+}
+
+func (m PermissionModel) GetAll() ([]Permission, error) {
+	var permissions []Permission
+	if err := m.DB.Order("code").Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// GetByCodes() returns the permissions with the given codes, unknown codes are left out.
+func (m PermissionModel) GetByCodes(codes []string) ([]Permission, error) {
+	var permissions []Permission
+	if err := m.DB.Where("code IN ?", codes).Order("code").Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+func (m PermissionModel) GetByCode(code string) (*Permission, error) {
+	var permission Permission
+	if err := m.DB.Where("code=?", code).First(&permission).Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &permission, nil
+}
+
+func (m PermissionModel) Grant(role *Role, permission *Permission) error {
+	return m.DB.Model(role).Association("Permissions").Append(permission)
+}
+
+func (m PermissionModel) Revoke(role *Role, permission *Permission) error {
+	return m.DB.Model(role).Association("Permissions").Delete(permission)
+}
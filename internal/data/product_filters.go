@@ -0,0 +1,148 @@
+package data
+
+import (
+	"strings"
+
+	"github.com/kubil6y/dukkan-go/internal/validator"
+	"gorm.io/gorm"
+)
+
+const productRatingSQL = "(SELECT COALESCE(AVG(ratings.value), 0) FROM ratings WHERE ratings.product_id = products.id)"
+
+// productSortColumns is the sort whitelist of the product listing,
+// a leading "-" on a sort key means descending order.
+var productSortColumns = map[string]string{
+	"price":      "products.price_amount",
+	"created_at": "products.created_at",
+	"name":       "products.name",
+	"rating":     productRatingSQL,
+}
+
+// ProductFilters are the query string filters of GET /v1/products,
+// zero values mean the filter is not used.
+type ProductFilters struct {
+	Search    string
+	Highlight bool
+	Category  string
+	Brands    []string
+	MinPrice  *int64
+	MaxPrice  *int64
+	InStock   bool
+	MinRating float64
+	Sort      []string
+}
+
+func ValidateProductFilters(f *ProductFilters, v *validator.Validator) {
+	if f.MinPrice != nil {
+		v.Check(*f.MinPrice >= 0, "min_price", "must be valid value")
+	}
+	if f.MaxPrice != nil {
+		v.Check(*f.MaxPrice >= 0, "max_price", "must be valid value")
+	}
+	if f.MinPrice != nil && f.MaxPrice != nil {
+		v.Check(*f.MinPrice <= *f.MaxPrice, "min_price", "must not be greater than max_price")
+	}
+	v.Check(f.MinRating >= 0 && f.MinRating <= 5, "min_rating", "must be between zero and five")
+
+	for _, key := range f.Sort {
+		_, ok := productSortColumns[strings.TrimPrefix(key, "-")]
+		v.Check(ok, "sort", "invalid sort value")
+	}
+}
+
+// scope() applies the filters, skip is the name of a filter to leave out
+// so facet counts of a dimension are not narrowed by its own selection.
+func (f ProductFilters) scope(skip string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if f.Search != "" {
+			db = db.Where("products.search_vector @@ websearch_to_tsquery(?, ?)", searchConfig, f.Search)
+		}
+		if f.Category != "" && skip != "category" {
+			db = db.Where("products.category_id IN (SELECT id FROM categories WHERE slug = ?)", f.Category)
+		}
+		if len(f.Brands) > 0 && skip != "brand" {
+			db = db.Where("lower(products.brand) IN ?", f.Brands)
+		}
+		if f.MinPrice != nil {
+			db = db.Where("products.price_amount >= ?", *f.MinPrice)
+		}
+		if f.MaxPrice != nil {
+			db = db.Where("products.price_amount <= ?", *f.MaxPrice)
+		}
+		if f.InStock {
+			db = db.Where("(products.count > 0 OR EXISTS (SELECT 1 FROM product_variants WHERE product_variants.product_id = products.id AND product_variants.count > 0))")
+		}
+		if f.MinRating > 0 {
+			db = db.Where(productRatingSQL+" >= ?", f.MinRating)
+		}
+		return db
+	}
+}
+
+// IsOrdered() reports whether the listing has an ordering other than by id,
+// keyset cursors can not be used then.
+func (f ProductFilters) IsOrdered() bool {
+	return f.Search != "" || len(f.Sort) > 0
+}
+
+// order() returns the ORDER BY of the filters, search results are ordered
+// by relevance unless a sort is given.
+func (f ProductFilters) order() string {
+	var columns []string
+	for _, key := range f.Sort {
+		column, ok := productSortColumns[strings.TrimPrefix(key, "-")]
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(key, "-") {
+			column += " DESC"
+		}
+		columns = append(columns, column)
+	}
+
+	if len(columns) == 0 && f.Search != "" {
+		columns = append(columns, "rank DESC")
+	}
+	return strings.Join(append(columns, "products.id"), ", ")
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+type ProductFacets struct {
+	Brands     []FacetCount `json:"brands"`
+	Categories []FacetCount `json:"categories"`
+}
+
+// Facets() counts the products matching the filters per brand and category.
+func (m ProductModel) Facets(f ProductFilters) (*ProductFacets, error) {
+	facets := ProductFacets{
+		Brands:     []FacetCount{},
+		Categories: []FacetCount{},
+	}
+
+	err := m.DB.Model(&Product{}).
+		Scopes(f.scope("brand")).
+		Select("lower(products.brand) AS value, count(*) AS count").
+		Group("lower(products.brand)").
+		Order("count DESC, value").
+		Scan(&facets.Brands).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.DB.Model(&Product{}).
+		Scopes(f.scope("category")).
+		Joins("JOIN categories ON categories.id = products.category_id").
+		Select("categories.slug AS value, count(*) AS count").
+		Group("categories.slug").
+		Order("count DESC, value").
+		Scan(&facets.Categories).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &facets, nil
+}
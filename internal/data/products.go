@@ -2,7 +2,6 @@ package data
 
 import (
 	"errors"
-	"fmt"
 
 	"gorm.io/gorm"
 )
@@ -15,33 +14,36 @@ var (
 
 type ProductWrapper struct {
 	Product
-	RatingAverage float64 `json:"rating_average"`
-	RatingCount   int     `json:"rating_count"`
-	ReviewCount   int     `json:"review_count"`
+	RatingAverage  float64             `json:"rating_average"`
+	RatingCount    int                 `json:"rating_count"`
+	ReviewCount    int                 `json:"review_count"`
+	VariantOptions map[string][]string `json:"variant_options,omitempty"`
 }
 
 func NewProductWrapper(product *Product) *ProductWrapper {
 	return &ProductWrapper{
-		Product:       *product,
-		RatingAverage: product.CalculateRating(),
-		RatingCount:   len(product.Ratings),
-		ReviewCount:   len(product.Reviews),
+		Product:        *product,
+		RatingAverage:  product.CalculateRating(),
+		RatingCount:    len(product.Ratings),
+		ReviewCount:    len(product.Reviews),
+		VariantOptions: product.VariantMatrix(),
 	}
 }
 
 type Product struct {
 	CoreModel
-	Name        string    `json:"name" gorm:"not null"`
-	Slug        string    `json:"slug" gorm:"uniqueIndex;not null"`
-	Description string    `json:"description" gorm:"not null"`
-	Brand       string    `json:"brand" gorm:"not null"`
-	Image       string    `json:"image" gorm:"not null"`
-	Price       float64   `json:"price" gorm:"not null"`
-	Count       int64     `json:"count" gorm:"not null"`
-	CategoryID  int64     `json:"category_id" gorm:"not null"`
-	Category    *Category `json:"category,omitempty"`
-	Reviews     []Review  `json:"reviews" gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"`
-	Ratings     []Rating  `json:"ratings" gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"`
+	Name        string           `json:"name" gorm:"not null"`
+	Slug        string           `json:"slug" gorm:"uniqueIndex;not null"`
+	Description string           `json:"description" gorm:"not null"`
+	Brand       string           `json:"brand" gorm:"not null"`
+	Image       string           `json:"image" gorm:"not null"`
+	Price       Money            `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	Count       int64            `json:"count" gorm:"not null"`
+	CategoryID  int64            `json:"category_id" gorm:"not null"`
+	Category    *Category        `json:"category,omitempty"`
+	Reviews     []Review         `json:"reviews" gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"`
+	Ratings     []Rating         `json:"ratings" gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"`
+	Variants    []ProductVariant `json:"variants,omitempty" gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"`
 }
 
 func (p *Product) CalculateRating() float64 {
@@ -92,18 +94,74 @@ func (m ProductModel) Insert(p *Product) error {
 	return m.DB.Create(p).Error
 }
 
-func (m ProductModel) GetAll(p *Paginate, searchTerm string) ([]Product, Metadata, error) {
-	var products []Product
+// GetAll() lists the products matching the filters, with full text search
+// when f.Search is set.
+func (m ProductModel) GetAll(p *Paginate, f ProductFilters) ([]ProductSearchResult, Metadata, error) {
+	type hit struct {
+		ID      int64
+		Rank    float64
+		Snippet string
+	}
 
-	err := m.DB.Scopes(p.PaginatedResults).Preload("Category").Where("name ILIKE ?", fmt.Sprintf("%%%s%%", searchTerm)).Find(&products).Error
+	query := m.DB.Table("products").Scopes(f.scope(""))
+	switch {
+	case f.Search != "" && f.Highlight:
+		query = query.Select(
+			"products.id, ts_rank(products.search_vector, websearch_to_tsquery(?, ?)) AS rank, "+
+				"ts_headline(?, translate(products.description, ?, ''), websearch_to_tsquery(?, ?), ?) AS snippet",
+			searchConfig, f.Search, searchConfig, headlineStartSel+headlineStopSel, searchConfig, f.Search, headlineOptions)
+	case f.Search != "":
+		query = query.Select("products.id, ts_rank(products.search_vector, websearch_to_tsquery(?, ?)) AS rank", searchConfig, f.Search)
+	default:
+		query = query.Select("products.id")
+	}
+
+	var hits []hit
+	err := query.Order(f.order()).Scopes(p.PaginatedResults).Scan(&hits).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
+	p.Arrange(hits)
+
+	ids := make([]int64, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+
+	var products []Product
+	if len(ids) > 0 {
+		if err := m.DB.Preload("Category").Where("id IN ?", ids).Find(&products).Error; err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	productsByID := make(map[int64]Product, len(products))
+	for _, product := range products {
+		productsByID[product.ID] = product
+	}
+
+	// keep the order of the listing query.
+	results := make([]ProductSearchResult, 0, len(hits))
+	for _, h := range hits {
+		product, ok := productsByID[h.ID]
+		if !ok {
+			continue
+		}
+		results = append(results, ProductSearchResult{
+			Product: product,
+			Rank:    h.Rank,
+			Snippet: highlightSnippet(h.Snippet),
+		})
+	}
 
 	var total int64
-	m.DB.Model(&Product{}).Where("name ILIKE ?", fmt.Sprintf("%%%s%%", searchTerm)).Count(&total)
+	m.DB.Model(&Product{}).Scopes(f.scope("")).Count(&total)
 	metadata := CalculateMetadata(p, int(total))
-	return products, metadata, nil
+	// cursors only work for the default ordering by id.
+	if len(hits) > 0 && !f.IsOrdered() {
+		metadata.SetCursors(p, hits[0].ID, hits[len(hits)-1].ID, len(hits))
+	}
+	return results, metadata, nil
 }
 
 func (m ProductModel) GetBySlug(slug string) (*Product, error) {
@@ -111,6 +169,9 @@ func (m ProductModel) GetBySlug(slug string) (*Product, error) {
 	err := m.DB.
 		Preload("Reviews.User").
 		Preload("Ratings").
+		Preload("Variants", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id")
+		}).
 		Where("slug=?", slug).
 		First(&product).Error
 	if err != nil {
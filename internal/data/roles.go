@@ -6,9 +6,13 @@ import (
 	"gorm.io/gorm"
 )
 
+// Role.RequireTwoFactor keeps users of the role from using its permissions
+// until they enabled two factor authentication.
 type Role struct {
 	CoreModel
-	Name string `json:"name" gorm:"uniqueIndex;not null"`
+	Name             string      `json:"name" gorm:"uniqueIndex;not null"`
+	RequireTwoFactor bool        `json:"require_two_factor" gorm:"not null;default:false"`
+	Permissions      Permissions `json:"permissions,omitempty" gorm:"many2many:role_permissions;constraint:OnDelete:CASCADE"`
 }
 
 type RoleModel struct {
@@ -43,7 +47,20 @@ func (m RoleModel) GetAll(p *Paginate) ([]Role, Metadata, error) {
 
 func (m RoleModel) GetByID(id int64) (*Role, error) {
 	var role Role
-	if err := m.DB.Where("id=?", id).First(&role).Error; err != nil {
+	if err := m.DB.Where("id=?", id).Preload("Permissions").First(&role).Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &role, nil
+}
+
+func (m RoleModel) GetByName(name string) (*Role, error) {
+	var role Role
+	if err := m.DB.Where("name=?", name).First(&role).Error; err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
 			return nil, ErrRecordNotFound
@@ -55,9 +72,12 @@ func (m RoleModel) GetByID(id int64) (*Role, error) {
 }
 
 func (m RoleModel) Update(role *Role) error {
-	return m.DB.Updates(role).Error
+	return m.DB.Model(role).Select("name", "require_two_factor", "updated_at").Updates(role).Error
 }
 
 func (m RoleModel) Delete(role *Role) error {
+	if err := m.DB.Model(role).Association("Permissions").Clear(); err != nil {
+		return err
+	}
 	return m.DB.Delete(role).Error
 }
@@ -0,0 +1,41 @@
+package data
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// searchConfig is the postgres text search configuration used for products,
+// the generated search_vector column (see internal/migrations) and the queries
+// must use the same one. Name is weighted above brand, and brand above description.
+const searchConfig = "english"
+
+// ProductSearchResult is a product of the listing with its search relevance,
+// Rank and Snippet are only set for full text searches.
+//
+// Snippet is an HTML fragment of the description: the description is HTML
+// escaped and the matching words are wrapped in <mark></mark>, it never holds
+// other tags so clients can render it as HTML.
+type ProductSearchResult struct {
+	Product
+	Rank    float64 `json:"rank,omitempty"`
+	Snippet string  `json:"snippet,omitempty"`
+}
+
+// ts_headline() marks the matches with these private use characters instead
+// of <mark>, it does not escape the document so the snippet is escaped after.
+// They are removed from the description first, so only matches get marked.
+const (
+	headlineStartSel = "\ue000"
+	headlineStopSel  = "\ue001"
+)
+
+var headlineOptions = fmt.Sprintf("StartSel=%s, StopSel=%s, MaxFragments=2", headlineStartSel, headlineStopSel)
+
+// highlightSnippet() escapes the snippet of ts_headline() and turns its
+// markers into <mark> tags.
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	return strings.NewReplacer(headlineStartSel, "<mark>", headlineStopSel, "</mark>").Replace(snippet)
+}
@@ -0,0 +1,21 @@
+package data
+
+import "testing"
+
+func TestHighlightSnippet(t *testing.T) {
+	tests := []struct {
+		snippet string
+		want    string
+	}{
+		{"a " + headlineStartSel + "chair" + headlineStopSel + " for you", "a <mark>chair</mark> for you"},
+		{"<script>alert(1)</script> " + headlineStartSel + "chair" + headlineStopSel, "&lt;script&gt;alert(1)&lt;/script&gt; <mark>chair</mark>"},
+		{`"quoted" & 'single'`, "&#34;quoted&#34; &amp; &#39;single&#39;"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := highlightSnippet(tt.snippet); got != tt.want {
+			t.Errorf("highlightSnippet(%q) = %q, want %q", tt.snippet, got, tt.want)
+		}
+	}
+}
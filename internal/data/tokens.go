@@ -4,25 +4,48 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
-	"sort"
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
 	ScopeAuthentication = "authentication"
 	ScopeActivation     = "activation"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeTwoFactor      = "two-factor"
+	ScopeEmailChange    = "email-change"
 )
 
+var ErrTokenReused = errors.New("refresh token was already used")
+
 type Token struct {
 	CoreModel
-	Scope     string    `json:"scope" gorm:"not null"`
-	Plaintext string    `json:"token" gorm:"-"`
-	Hash      []byte    `json:"-" gorm:"not null"`
-	Code      string    `json:"code" gorm:"-"`
-	Expiry    time.Time `json:"expiry"`
-	UserID    int64     `json:"user_id" gorm:"not null"`
+	Scope      string     `json:"scope" gorm:"not null"`
+	Plaintext  string     `json:"token" gorm:"-"`
+	Hash       []byte     `json:"-" gorm:"not null"`
+	Code       string     `json:"code" gorm:"-"`
+	Expiry     time.Time  `json:"expiry"`
+	UserID     int64      `json:"user_id" gorm:"not null"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	UserAgent  string     `json:"user_agent" gorm:"not null;default:''"`
+	IP         string     `json:"ip" gorm:"not null;default:''"`
+	FamilyID   string     `json:"-" gorm:"index;not null;default:''"`
+	UsedAt     *time.Time `json:"-"`
+}
+
+// Session is the client view of an authentication token.
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	Current    bool       `json:"current"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -32,13 +55,13 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 		Expiry: time.Now().Add(ttl),
 	}
 
-	b := make([]byte, 16)
-	if _, err := rand.Read(b); err != nil {
+	plaintext, err := randomString()
+	if err != nil {
 		return nil, err
 	}
 
 	// example plain token: Y3QMGX3PJ3WLRL2YRTQGQ6KRHU
-	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	token.Plaintext = plaintext
 
 	// one way hash with no salt, user will send plain token...
 	hash := sha256.Sum256([]byte(token.Plaintext))
@@ -47,6 +70,15 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	return token, nil
 }
 
+// randomString() returns 16 random bytes as 26 base32 characters.
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
 type TokenModel struct {
 	DB *gorm.DB
 }
@@ -65,6 +97,245 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewSession() creates a short lived authentication (access) token and
+// a long lived refresh token, both remember the client they were created for.
+// The tokens start a new family, every token rotated from them joins it.
+func (m TokenModel) NewSession(userID int64, accessTTL, refreshTTL time.Duration, userAgent, ip string) (*Token, *Token, error) {
+	familyID, err := randomString()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx := m.DB.Begin()
+	access, refresh, err := newSessionTx(tx, userID, familyID, accessTTL, refreshTTL, userAgent, ip)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, nil
+}
+
+func newSessionTx(tx *gorm.DB, userID int64, familyID string, accessTTL, refreshTTL time.Duration, userAgent, ip string) (*Token, *Token, error) {
+	access, err := generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*Token{access, refresh} {
+		token.FamilyID = familyID
+		token.UserAgent = userAgent
+		token.IP = ip
+		if err := tx.Create(token).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	return access, refresh, nil
+}
+
+// Rotate() trades a refresh token for a new access and refresh token pair.
+// Used refresh tokens are kept until they expire, presenting one again means
+// it was stolen, so the whole family is revoked and ErrTokenReused is returned.
+func (m TokenModel) Rotate(refreshPlaintext string, accessTTL, refreshTTL time.Duration, userAgent, ip string) (*Token, *Token, error) {
+	hash := sha256.Sum256([]byte(refreshPlaintext))
+
+	tx := m.DB.Begin()
+
+	var token Token
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("hash=? and scope=? and expiry > ?", hash[:], ScopeRefresh, time.Now()).
+		First(&token).Error
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if token.UsedAt != nil {
+		if err := tx.Where("user_id=? and family_id=?", token.UserID, token.FamilyID).Delete(&Token{}).Error; err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+		if err := tx.Commit().Error; err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrTokenReused
+	}
+
+	if err := tx.Model(&token).UpdateColumn("used_at", time.Now()).Error; err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	// the family has one access token at a time, the old one is logged out.
+	err = tx.Where("user_id=? and family_id=? and scope=?", token.UserID, token.FamilyID, ScopeAuthentication).Delete(&Token{}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	access, refresh, err := newSessionTx(tx, token.UserID, token.FamilyID, accessTTL, refreshTTL, userAgent, ip)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, nil
+}
+
+// Touch() records the use of the token, at most once a minute to keep writes low.
+func (m TokenModel) Touch(token *Token) error {
+	now := time.Now()
+	return m.DB.Model(&Token{}).
+		Where("id=? and (last_used_at IS NULL or last_used_at < ?)", token.ID, now.Add(-time.Minute)).
+		UpdateColumn("last_used_at", now).Error
+}
+
+// sessionFamily is a session with the ids of its tokens, tokens issued
+// before refresh tokens existed have no family and are a session of their own.
+type sessionFamily struct {
+	Session
+	familyID string
+	tokenIDs []int64
+	alive    bool
+}
+
+// sessionFamilies() groups the unexpired authentication and refresh tokens
+// of the user into sessions, the session with the newest token comes first.
+// A session is alive while it has an unused refresh token or an access token.
+func (m TokenModel) sessionFamilies(userID int64) ([]*sessionFamily, error) {
+	var tokens []Token
+	err := m.DB.
+		Where("user_id=? and scope IN ? and expiry > ?", userID, []string{ScopeAuthentication, ScopeRefresh}, time.Now()).
+		Order("created_at DESC, id DESC").
+		Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var families []*sessionFamily
+	byFamily := make(map[string]*sessionFamily)
+	for _, token := range tokens {
+		f, ok := byFamily[token.FamilyID]
+		if !ok || token.FamilyID == "" {
+			// tokens are newest first, the first one describes the client.
+			f = &sessionFamily{
+				Session: Session{
+					ID:        token.ID,
+					UserAgent: token.UserAgent,
+					IP:        token.IP,
+				},
+				familyID: token.FamilyID,
+			}
+			families = append(families, f)
+			if token.FamilyID != "" {
+				byFamily[token.FamilyID] = f
+			}
+		}
+
+		f.tokenIDs = append(f.tokenIDs, token.ID)
+		f.CreatedAt = token.CreatedAt
+		if token.LastUsedAt != nil && (f.LastUsedAt == nil || token.LastUsedAt.After(*f.LastUsedAt)) {
+			f.LastUsedAt = token.LastUsedAt
+		}
+
+		if token.Scope == ScopeRefresh && token.UsedAt != nil {
+			continue
+		}
+		// the unused refresh token outlives the access token, it is
+		// the id and expiry of the session.
+		if !f.alive || (token.Scope == ScopeRefresh && token.Expiry.After(f.Expiry)) {
+			f.ID = token.ID
+			f.Expiry = token.Expiry
+		}
+		f.alive = true
+	}
+
+	alive := families[:0]
+	for _, f := range families {
+		if f.alive {
+			alive = append(alive, f)
+		}
+	}
+	return alive, nil
+}
+
+// GetSessions() lists the sessions of the user, one for every refresh token
+// family. current is the token of the request, its session is marked.
+func (m TokenModel) GetSessions(userID int64, current *Token) ([]Session, error) {
+	families, err := m.sessionFamilies(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, len(families))
+	for i, f := range families {
+		sessions[i] = f.Session
+		if current == nil {
+			continue
+		}
+		if current.FamilyID != "" {
+			sessions[i].Current = f.familyID == current.FamilyID
+		} else {
+			sessions[i].Current = f.familyID == "" && f.ID == current.ID
+		}
+	}
+	return sessions, nil
+}
+
+// DeleteSession() revokes the session of one of the user's authentication
+// or refresh tokens, with every token of its family.
+func (m TokenModel) DeleteSession(userID, id int64) error {
+	var token Token
+	err := m.DB.Where("id=? and user_id=? and scope IN ?", id, userID, []string{ScopeAuthentication, ScopeRefresh}).First(&token).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if token.FamilyID == "" {
+		return m.DB.Delete(&token).Error
+	}
+	return m.DB.Where("user_id=? and family_id=?", userID, token.FamilyID).Delete(&Token{}).Error
+}
+
+// DeleteOtherSessions() logs the user out of every session but the one of
+// current, and deletes the password reset tokens of the user. It is used
+// when the password changes, current can be nil.
+func (m TokenModel) DeleteOtherSessions(userID int64, current *Token) error {
+	query := m.DB.Where("user_id=? and scope IN ?", userID, []string{ScopeAuthentication, ScopeRefresh, ScopePasswordReset})
+	switch {
+	case current == nil:
+	case current.FamilyID != "":
+		query = query.Where("family_id <> ?", current.FamilyID)
+	default:
+		query = query.Where("id <> ?", current.ID)
+	}
+	return query.Delete(&Token{}).Error
+}
+
+func (m TokenModel) DeleteAllForUser(userID int64, scopes ...string) error {
+	return m.DB.Where("user_id=? and scope IN ?", userID, scopes).Delete(&Token{}).Error
+}
+
 func (m TokenModel) ActivateUserAndDeleteToken(tokenPlaintext string, user *User) error {
 	tx := m.DB.Begin()
 	if err := tx.Model(user).Updates(user).Error; err != nil {
@@ -81,23 +352,150 @@ func (m TokenModel) ActivateUserAndDeleteToken(tokenPlaintext string, user *User
 	return nil
 }
 
-func (m TokenModel) KeepLastFiveAuthTokens(userID int64) error {
-	var tokens []Token
+// KeepLastFiveSessions() revokes every session of the user but the five
+// with the newest tokens, refresh tokens are revoked with their family.
+func (m TokenModel) KeepLastFiveSessions(userID int64) error {
+	families, err := m.sessionFamilies(userID)
+	if err != nil {
+		return err
+	}
+	if len(families) <= 5 {
+		return nil
+	}
+
+	var familyIDs []string
+	var tokenIDs []int64
+	for _, f := range families[5:] {
+		if f.familyID != "" {
+			familyIDs = append(familyIDs, f.familyID)
+		} else {
+			tokenIDs = append(tokenIDs, f.tokenIDs...)
+		}
+	}
+
+	tx := m.DB.Begin()
+	if len(familyIDs) > 0 {
+		if err := tx.Where("user_id=? and family_id IN ?", userID, familyIDs).Delete(&Token{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if len(tokenIDs) > 0 {
+		if err := tx.Where("user_id=? and id IN ?", userID, tokenIDs).Delete(&Token{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// ResetPassword() saves the new password of the user, and deletes the user's
+// password reset and authentication tokens, so every old session is logged out.
+func (m TokenModel) ResetPassword(user *User) error {
+	tx := m.DB.Begin()
+	if err := tx.Model(user).Update("password", user.Password).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	scopes := []string{ScopePasswordReset, ScopeAuthentication, ScopeRefresh}
+	if err := tx.Where("user_id=? and scope IN ?", user.ID, scopes).Delete(&Token{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
 
-	if err := m.DB.Where("user_id=? and scope=?", userID, ScopeAuthentication).Find(&tokens).Error; err != nil {
+// SetPendingEmail() stores the new email of the user as pending, email
+// change tokens of an earlier pending email stop working.
+func (m TokenModel) SetPendingEmail(user *User, email string) error {
+	tx := m.DB.Begin()
+	if err := tx.Model(user).Update("pending_email", email).Error; err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	sort.Slice(tokens, func(i, j int) bool {
-		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
-	})
+	if err := tx.Where("user_id=? and scope=?", user.ID, ScopeEmailChange).Delete(&Token{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// NewEmailChange() returns the token which confirms email as the new email
+// of the user, older email change tokens stop working. ErrRecordNotFound
+// means email is not pending anymore.
+func (m TokenModel) NewEmailChange(userID int64, email string, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := m.DB.Begin()
+
+	// SetPendingEmail() waits for the lock, so the token can't confirm
+	// an email which replaced this one in the meantime.
+	var user User
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id=? and pending_email=?", userID, email).
+		First(&user).Error
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if err := tx.Where("user_id=? and scope=?", userID, ScopeEmailChange).Delete(&Token{}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Create(token).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return token, nil
+}
 
-	if len(tokens) > 5 {
-		args := []interface{}{userID, ScopeAuthentication, tokens[4].CreatedAt}
-		if err := m.DB.Where("user_id=? and scope=? and created_at < ?", args...).Delete(&Token{}).Error; err != nil {
+// ConfirmEmailChange() swaps the email of the user with the pending one and
+// logs the user out everywhere, ErrDuplicateRecord means another user took
+// the address in the meantime. Updates() writes the new values into user.
+func (m TokenModel) ConfirmEmailChange(user *User) error {
+	if user.PendingEmail == "" {
+		return ErrRecordNotFound
+	}
+
+	tx := m.DB.Begin()
+	err := tx.Model(user).Updates(map[string]interface{}{
+		"email":         user.PendingEmail,
+		"pending_email": "",
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case IsDuplicateRecord(err):
+			return ErrDuplicateRecord
+		default:
 			return err
 		}
 	}
 
-	return nil
+	// password reset codes went to the old address.
+	scopes := []string{ScopeEmailChange, ScopePasswordReset, ScopeAuthentication, ScopeRefresh}
+	if err := tx.Where("user_id=? and scope IN ?", user.ID, scopes).Delete(&Token{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
 }
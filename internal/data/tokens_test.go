@@ -0,0 +1,129 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSessionsAreRefreshTokenFamilies(t *testing.T) {
+	db := newTestDB(t)
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	role := Role{Name: "sessions-" + suffix}
+	if err := db.Create(&role).Error; err != nil {
+		t.Fatal(err)
+	}
+	user := User{FirstName: "test", LastName: "test", Email: suffix + "@example.com", Password: []byte("-"), RoleID: role.ID}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Where("user_id=?", user.ID).Delete(&Token{})
+		db.Delete(&user)
+		db.Delete(&role)
+	})
+
+	m := TokenModel{DB: db}
+
+	// the first session outlives its short access token.
+	_, oldest, err := m.NewSession(user.ID, time.Millisecond, time.Hour, "oldest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	var refresh *Token
+	for i := 0; i < 6; i++ {
+		_, refresh, err = m.NewSession(user.ID, time.Hour, time.Hour, fmt.Sprint("client-", i), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// rotating keeps the session, it does not add one.
+	access, _, err := m.Rotate(refresh.Plaintext, time.Hour, time.Hour, "client-5", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := m.GetSessions(user.ID, access)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 7 {
+		t.Fatalf("got %d sessions, want 7", len(sessions))
+	}
+	if !sessions[0].Current || sessions[0].UserAgent != "client-5" {
+		t.Errorf("first session = %+v, want the current one of client-5", sessions[0])
+	}
+	if last := sessions[len(sessions)-1]; last.UserAgent != "oldest" {
+		t.Errorf("last session = %+v, want the oldest one", last)
+	}
+
+	if err := m.KeepLastFiveSessions(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err = m.GetSessions(user.ID, access)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 5 {
+		t.Fatalf("got %d sessions after pruning, want 5", len(sessions))
+	}
+
+	// the refresh token of a pruned session is revoked with its family.
+	if _, _, err := m.Rotate(oldest.Plaintext, time.Hour, time.Hour, "oldest", ""); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Rotate() of a pruned session = %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestDeleteOtherSessionsKeepsTheCurrentOne(t *testing.T) {
+	db := newTestDB(t)
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	role := Role{Name: "other-sessions-" + suffix}
+	if err := db.Create(&role).Error; err != nil {
+		t.Fatal(err)
+	}
+	user := User{FirstName: "test", LastName: "test", Email: suffix + "@example.com", Password: []byte("-"), RoleID: role.ID}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Where("user_id=?", user.ID).Delete(&Token{})
+		db.Delete(&user)
+		db.Delete(&role)
+	})
+
+	m := TokenModel{DB: db}
+	access, refresh, err := m.NewSession(user.ID, time.Hour, time.Hour, "current", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherRefresh, err := m.NewSession(user.ID, time.Hour, time.Hour, "other", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.New(user.ID, time.Hour, ScopePasswordReset); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.DeleteOtherSessions(user.ID, access); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := m.Rotate(otherRefresh.Plaintext, time.Hour, time.Hour, "other", ""); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Rotate() of another session = %v, want ErrRecordNotFound", err)
+	}
+	if _, _, err := m.Rotate(refresh.Plaintext, time.Hour, time.Hour, "current", ""); err != nil {
+		t.Errorf("Rotate() of the current session = %v", err)
+	}
+
+	var resets int64
+	db.Model(&Token{}).Where("user_id=? and scope=?", user.ID, ScopePasswordReset).Count(&resets)
+	if resets != 0 {
+		t.Errorf("%d password reset tokens are left, want 0", resets)
+	}
+}
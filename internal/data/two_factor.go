@@ -0,0 +1,244 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kubil6y/dukkan-go/internal/totp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidTwoFactorCode = errors.New("invalid two factor code")
+	ErrTwoFactorEnabled     = errors.New("two factor authentication is already enabled")
+	ErrTwoFactorDisabled    = errors.New("two factor authentication is not enabled")
+)
+
+const recoveryCodeCount = 10
+
+// RecoveryCode is a one time code which can be used instead of a TOTP code,
+// e.g. when the user lost their phone. Only the hash is stored.
+type RecoveryCode struct {
+	CoreModel
+	UserID int64      `json:"-" gorm:"index;not null"`
+	User   *User      `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Hash   []byte     `json:"-" gorm:"not null"`
+	UsedAt *time.Time `json:"-"`
+}
+
+// generateRecoveryCode() returns a code like 7KQ2M-XD4PA.
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := base32.StdEncoding.EncodeToString(b)[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func hashRecoveryCode(code string) []byte {
+	normalized := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(normalized))
+	return hash[:]
+}
+
+type TwoFactorModel struct {
+	DB *gorm.DB
+}
+
+// Enroll() stores a new secret for the user, two factor authentication
+// stays disabled until the user confirms a code generated from it.
+func (m TwoFactorModel) Enroll(user *User) (string, error) {
+	if user.TwoFactorEnabled {
+		return "", ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	err = m.DB.Model(user).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	user.TOTPSecret = secret
+	return secret, nil
+}
+
+// Confirm() enables two factor authentication when the code matches the
+// enrolled secret, and returns the plain recovery codes of the user.
+func (m TwoFactorModel) Confirm(user *User, code string) ([]string, error) {
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorDisabled
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	tx := m.DB.Begin()
+	err := tx.Model(user).Updates(map[string]interface{}{
+		"two_factor_enabled": true,
+		"totp_last_step":     step,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	codes, err := newRecoveryCodesTx(tx, user.ID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	user.TwoFactorEnabled = true
+	user.TOTPLastStep = step
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes() replaces every recovery code of the user.
+func (m TwoFactorModel) RegenerateRecoveryCodes(user *User) ([]string, error) {
+	if !user.TwoFactorEnabled {
+		return nil, ErrTwoFactorDisabled
+	}
+
+	tx := m.DB.Begin()
+	codes, err := newRecoveryCodesTx(tx, user.ID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func newRecoveryCodesTx(tx *gorm.DB, userID int64) ([]string, error) {
+	if err := tx.Where("user_id=?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		rows[i] = RecoveryCode{UserID: userID, Hash: hashRecoveryCode(code)}
+	}
+
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify() checks a TOTP code, or a recovery code when recoveryCode is set.
+// TOTP codes are accepted once, recovery codes are marked as used.
+func (m TwoFactorModel) Verify(user *User, code, recoveryCode string) error {
+	if !user.TwoFactorEnabled {
+		return ErrTwoFactorDisabled
+	}
+
+	if recoveryCode != "" {
+		return m.useRecoveryCode(user, recoveryCode)
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	// the conditional update makes concurrent requests with the same code fail.
+	result := m.DB.Model(&User{}).
+		Where("id=? and totp_last_step < ?", user.ID, step).
+		UpdateColumn("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	user.TOTPLastStep = step
+	return nil
+}
+
+func (m TwoFactorModel) useRecoveryCode(user *User, code string) error {
+	tx := m.DB.Begin()
+
+	var rc RecoveryCode
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id=? and hash=? and used_at IS NULL", user.ID, hashRecoveryCode(code)).
+		First(&rc).Error
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return ErrInvalidTwoFactorCode
+		default:
+			return err
+		}
+	}
+
+	if err := tx.Model(&rc).UpdateColumn("used_at", time.Now()).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// RemainingRecoveryCodes() returns how many unused recovery codes the user has.
+func (m TwoFactorModel) RemainingRecoveryCodes(user *User) (int64, error) {
+	var count int64
+	err := m.DB.Model(&RecoveryCode{}).Where("user_id=? and used_at IS NULL", user.ID).Count(&count).Error
+	return count, err
+}
+
+// Disable() turns two factor authentication off and removes the secret
+// and the recovery codes of the user.
+func (m TwoFactorModel) Disable(user *User) error {
+	tx := m.DB.Begin()
+	err := tx.Model(user).Updates(map[string]interface{}{
+		"two_factor_enabled": false,
+		"totp_secret":        "",
+		"totp_last_step":     0,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Where("user_id=?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	user.TwoFactorEnabled = false
+	user.TOTPSecret = ""
+	return nil
+}
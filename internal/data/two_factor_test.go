@@ -0,0 +1,63 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kubil6y/dukkan-go/internal/totp"
+)
+
+func TestVerifyRejectsReplayedCodes(t *testing.T) {
+	db := newTestDB(t)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	role := Role{Name: "two-factor-" + suffix}
+	if err := db.Create(&role).Error; err != nil {
+		t.Fatal(err)
+	}
+	user := User{
+		FirstName:        "test",
+		LastName:         "test",
+		Email:            suffix + "@example.com",
+		Password:         []byte("-"),
+		RoleID:           role.ID,
+		TwoFactorEnabled: true,
+		TOTPSecret:       secret,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Delete(&user)
+		db.Delete(&role)
+	})
+
+	m := TwoFactorModel{DB: db}
+	current := totp.Step(time.Now())
+	previous, err := totp.Code(secret, current-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := totp.Code(secret, current+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Verify(&user, next, ""); err != nil {
+		t.Fatalf("Verify() of a fresh code = %v", err)
+	}
+	if err := m.Verify(&user, next, ""); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("Verify() of a replayed code = %v, want ErrInvalidTwoFactorCode", err)
+	}
+	// codes of earlier steps are refused once a later one was used.
+	if err := m.Verify(&user, previous, ""); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("Verify() of an older code = %v, want ErrInvalidTwoFactorCode", err)
+	}
+}
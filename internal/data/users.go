@@ -6,7 +6,6 @@ import (
 	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -14,30 +13,41 @@ var AnonUser = &User{}
 
 type User struct {
 	CoreModel
-	FirstName   string   `json:"first_name" gorm:"not null"`
-	LastName    string   `json:"last_name" gorm:"not null"`
-	Email       string   `json:"email" gorm:"uniqueIndex;not null"`
-	Password    []byte   `json:"-" gorm:"not null"`
-	Address     string   `json:"address" gorm:"not null"`
-	IsActivated bool     `json:"is_activated" gorm:"default:false;not null"`
-	RoleID      int64    `json:"-" gorm:"not null"`
-	Role        *Role    `json:"role,omitempty"`
-	Tokens      []Token  `json:"tokens,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
-	Reviews     []Review `json:"reviews,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL"`
-	Ratings     []Rating `json:"ratings,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL"`
-	Orders      []Order  `json:"orders,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL"`
+	FirstName        string   `json:"first_name" gorm:"not null"`
+	LastName         string   `json:"last_name" gorm:"not null"`
+	Email            string   `json:"email" gorm:"uniqueIndex;not null"`
+	PendingEmail     string   `json:"pending_email,omitempty" gorm:"not null;default:''"`
+	Password         []byte   `json:"-" gorm:"not null"`
+	Address          string   `json:"address" gorm:"not null"`
+	IsActivated      bool     `json:"is_activated" gorm:"default:false;not null"`
+	RoleID           int64    `json:"-" gorm:"not null"`
+	TwoFactorEnabled bool     `json:"two_factor_enabled" gorm:"not null;default:false"`
+	TOTPSecret       string   `json:"-" gorm:"column:totp_secret;not null;default:''"`
+	TOTPLastStep     int64    `json:"-" gorm:"column:totp_last_step;not null;default:0"`
+	APIKey           *APIKey  `json:"-" gorm:"-"`
+	Role             *Role    `json:"role,omitempty"`
+	Tokens           []Token  `json:"tokens,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Reviews          []Review `json:"reviews,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL"`
+	Ratings          []Rating `json:"ratings,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL"`
+	Orders           []Order  `json:"orders,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL"`
 }
 
 func (u *User) IsAnon() bool {
 	return u == AnonUser
 }
 
+// IsService() reports whether the request was made with an API key.
+func (u *User) IsService() bool {
+	return u.APIKey != nil
+}
+
 func (u *User) FullName() string {
 	return strings.Title(u.FirstName + " " + u.LastName)
 }
 
+// SetPassword() hashes the password with the current PasswordHashing.
 func (u *User) SetPassword(plain string) error {
-	h, err := bcrypt.GenerateFromPassword([]byte(plain), bcrypt.DefaultCost)
+	h, err := hashPassword(plain)
 	if err != nil {
 		return err
 	}
@@ -45,16 +55,15 @@ func (u *User) SetPassword(plain string) error {
 	return nil
 }
 
+// ComparePassword() verifies bcrypt and argon2id hashes.
 func (u *User) ComparePassword(plain string) (bool, error) {
-	if err := bcrypt.CompareHashAndPassword(u.Password, []byte(plain)); err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
-	}
-	return true, nil
+	return comparePassword(u.Password, plain)
+}
+
+// PasswordNeedsRehash() reports whether the password should be hashed again
+// with SetPassword(), it is checked after a successful login.
+func (u *User) PasswordNeedsRehash() bool {
+	return needsRehash(u.Password)
 }
 
 func (u *User) DidOrderProduct(product *Product) (bool, error) {
@@ -92,14 +101,18 @@ func (m UserModel) Insert(u *User) error {
 func (m UserModel) GetAll(p *Paginate) ([]User, Metadata, error) {
 	var users []User
 
-	err := m.DB.Scopes(p.PaginatedResults).Preload("Role").Find(&users).Error
+	err := m.DB.Order("id").Scopes(p.PaginatedResults).Preload("Role").Find(&users).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
+	p.Arrange(users)
 
 	var total int64
 	m.DB.Model(&User{}).Count(&total)
 	metadata := CalculateMetadata(p, int(total))
+	if len(users) > 0 {
+		metadata.SetCursors(p, users[0].ID, users[len(users)-1].ID, len(users))
+	}
 	return users, metadata, nil
 }
 
@@ -135,11 +148,21 @@ func (m UserModel) Update(u *User) error {
 	return m.DB.Model(u).Updates(u).Error
 }
 
+func (m UserModel) UpdatePassword(u *User) error {
+	return m.DB.Model(u).UpdateColumn("password", u.Password).Error
+}
+
 func (m UserModel) Delete(u *User) error {
 	return m.DB.Delete(u).Error
 }
 
 func (m UserModel) GetForToken(scope string, tokenPlaintext string) (*User, error) {
+	user, _, err := m.GetSessionForToken(scope, tokenPlaintext)
+	return user, err
+}
+
+// GetSessionForToken() is GetForToken() which also returns the token itself.
+func (m UserModel) GetSessionForToken(scope string, tokenPlaintext string) (*User, *Token, error) {
 	sizedTokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	tokenHash := sizedTokenHash[:]
 
@@ -148,19 +171,27 @@ func (m UserModel) GetForToken(scope string, tokenPlaintext string) (*User, erro
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
-			return nil, ErrRecordNotFound
+			return nil, nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
 	var user User
 	err = m.DB.
 		Where("id=?", token.UserID).
-		Preload("Role").
+		Preload("Role.Permissions").
 		First(&user).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
 
-	return &user, nil
+	return &user, &token, nil
 }
 
 func (m UserModel) GetUserWithOrders(id int64) (*User, error) {
@@ -0,0 +1,143 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrVariantRequired = errors.New("a variant of the product must be selected")
+	ErrInvalidVariant  = errors.New("variant does not belong to the product")
+)
+
+// VariantOptions holds the option values of a variant, e.g. {"size": "m", "color": "red"}
+// it is stored as jsonb.
+type VariantOptions map[string]string
+
+func (o VariantOptions) Value() (driver.Value, error) {
+	if o == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(o)
+	return string(b), err
+}
+
+func (o *VariantOptions) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, o)
+	case string:
+		return json.Unmarshal([]byte(v), o)
+	default:
+		return fmt.Errorf("can not scan %T into VariantOptions", value)
+	}
+}
+
+func (VariantOptions) GormDataType() string {
+	return "jsonb"
+}
+
+// ProductVariant is a sellable version of a product with its own stock,
+// products with variants are ordered through their variants.
+type ProductVariant struct {
+	CoreModel
+	ProductID     int64          `json:"product_id" gorm:"index;not null"`
+	SKU           string         `json:"sku" gorm:"uniqueIndex;not null"`
+	Options       VariantOptions `json:"options" gorm:"not null"`
+	PriceOverride *int64         `json:"price_override,omitempty"`
+	Count         int64          `json:"count" gorm:"not null"`
+}
+
+// PriceFor() returns the price of the variant, which is the product's price
+// unless the variant overrides it.
+func (v *ProductVariant) PriceFor(product *Product) Money {
+	if v.PriceOverride == nil {
+		return product.Price
+	}
+	return NewMoney(*v.PriceOverride, product.Price.Currency)
+}
+
+// VariantMatrix() returns every option of the product's variants
+// with its values, e.g. {"color": ["blue", "red"], "size": ["l", "m"]}
+func (p *Product) VariantMatrix() map[string][]string {
+	if len(p.Variants) == 0 {
+		return nil
+	}
+
+	seen := make(map[string]map[string]bool)
+	for _, variant := range p.Variants {
+		for name, value := range variant.Options {
+			if seen[name] == nil {
+				seen[name] = make(map[string]bool)
+			}
+			seen[name][value] = true
+		}
+	}
+
+	matrix := make(map[string][]string, len(seen))
+	for name, values := range seen {
+		for value := range values {
+			matrix[name] = append(matrix[name], value)
+		}
+		sort.Strings(matrix[name])
+	}
+	return matrix
+}
+
+type ProductVariantModel struct {
+	DB *gorm.DB
+}
+
+func (m ProductVariantModel) Insert(v *ProductVariant) error {
+	if err := m.DB.Create(v).Error; err != nil {
+		switch {
+		case IsDuplicateRecord(err):
+			return ErrDuplicateRecord
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ProductVariantModel) GetByID(id int64) (*ProductVariant, error) {
+	var variant ProductVariant
+	if err := m.DB.Where("id=?", id).First(&variant).Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &variant, nil
+}
+
+func (m ProductVariantModel) GetAllByProductID(productID int64) ([]ProductVariant, error) {
+	var variants []ProductVariant
+	if err := m.DB.Where("product_id=?", productID).Order("id").Find(&variants).Error; err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
+
+func (m ProductVariantModel) Update(v *ProductVariant) error {
+	if err := m.DB.Save(v).Error; err != nil {
+		switch {
+		case IsDuplicateRecord(err):
+			return ErrDuplicateRecord
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ProductVariantModel) Delete(v *ProductVariant) error {
+	return m.DB.Delete(v).Error
+}
@@ -1,40 +1,127 @@
+// Package email builds the emails of the application from the templates in
+// templates/ and delivers them through a Mailer.
+//
+// Every email has a <name>.txt.tmpl which defines "subject" and "body", and a
+// <name>.html.tmpl which defines "body". Bodies are rendered in the "layout"
+// of layout.txt.tmpl and layout.html.tmpl.
 package email
 
 import (
-	"fmt"
+	"bytes"
+	"context"
+	"embed"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
 
 	"github.com/kubil6y/dukkan-go/internal/data"
 )
 
-// TODO
-func ActivationEmail(user *data.User, code string) {
-	/*
-		from := mail.NewEmail("Dukkan", "lieqb2@gmail.com")
-		subject := "Dukkan - Account Activation Code"
-		to := mail.NewEmail(user.FullName(), user.Email)
-		plainTextContent := fmt.Sprintf("Activation Code: %s", code)
-
-		htmlContent := fmt.Sprintf(`
-				<div>
-					<h4>Dukkan!</h4>
-					<p><strong>Activation Code: %s</strong></p>
-					<small>
-						Please visit <a href="%s/tokens/activation">activation page.</a>
-					</small>
-				</div>`, code, os.Getenv("DOMAIN"))
-
-		message := mail.NewSingleEmail(from, subject, to, plainTextContent, htmlContent)
-		client := sendgrid.NewSendClient(os.Getenv("SENDGRID_API_KEY"))
-		response, err := client.Send(message)
+//go:embed templates
+var templateFS embed.FS
+
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers a message, it stops when ctx is canceled.
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+type template struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Sender renders the emails of the application and sends them with its Mailer.
+type Sender struct {
+	mailer    Mailer
+	from      string
+	domain    string
+	templates map[string]template
+}
+
+var templateNames = []string{"activation", "password_reset", "email_change", "email_change_notice"}
+
+// NewSender() parses all templates, so broken templates are found at startup.
+func NewSender(mailer Mailer, from, domain string) (*Sender, error) {
+	s := &Sender{
+		mailer:    mailer,
+		from:      from,
+		domain:    domain,
+		templates: make(map[string]template),
+	}
+
+	for _, name := range templateNames {
+		text, err := texttemplate.ParseFS(templateFS, "templates/layout.txt.tmpl", "templates/"+name+".txt.tmpl")
+		if err != nil {
+			return nil, err
+		}
+		html, err := htmltemplate.ParseFS(templateFS, "templates/layout.html.tmpl", "templates/"+name+".html.tmpl")
 		if err != nil {
-			log.Println(err)
-		} else {
-			fmt.Println(response.StatusCode)
-			fmt.Println(response.Body)
-			fmt.Println(response.Headers)
+			return nil, err
 		}
-	*/
+		s.templates[name] = template{text: text, html: html}
+	}
+	return s, nil
+}
+
+func (s *Sender) send(ctx context.Context, to, name string, data map[string]interface{}) error {
+	data["Domain"] = s.domain
+
+	t := s.templates[name]
+	var subject, text, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return err
+	}
+	if err := t.text.ExecuteTemplate(&text, "layout", data); err != nil {
+		return err
+	}
+	if err := t.html.ExecuteTemplate(&html, "layout", data); err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, &Message{
+		From:    s.from,
+		To:      []string{to},
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	})
+}
+
+func (s *Sender) ActivationEmail(ctx context.Context, user *data.User, code string) error {
+	return s.send(ctx, user.Email, "activation", map[string]interface{}{
+		"User": user,
+		"Code": code,
+	})
+}
+
+func (s *Sender) PasswordResetEmail(ctx context.Context, user *data.User, code string) error {
+	return s.send(ctx, user.Email, "password_reset", map[string]interface{}{
+		"User": user,
+		"Code": code,
+	})
+}
+
+// EmailChangeEmail() sends the confirmation code to the new address.
+func (s *Sender) EmailChangeEmail(ctx context.Context, user *data.User, newEmail, code string) error {
+	return s.send(ctx, newEmail, "email_change", map[string]interface{}{
+		"User":     user,
+		"Code":     code,
+		"NewEmail": newEmail,
+	})
+}
 
-	fmt.Println("email sent in background...")
-	fmt.Println(code)
+// EmailChangeNoticeEmail() tells the old address about the change.
+func (s *Sender) EmailChangeNoticeEmail(ctx context.Context, user *data.User, newEmail string) error {
+	return s.send(ctx, user.Email, "email_change_notice", map[string]interface{}{
+		"User":     user,
+		"NewEmail": newEmail,
+	})
 }
@@ -0,0 +1,150 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubil6y/dukkan-go/internal/data"
+	"github.com/kubil6y/dukkan-go/internal/email"
+	"github.com/kubil6y/dukkan-go/internal/email/smtptest"
+)
+
+func TestSenderThroughSMTP(t *testing.T) {
+	srv, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	mailer := email.SMTPMailer{Host: srv.Host, Port: srv.Port}
+	sender, err := email.NewSender(mailer, "Dukkan <no-reply@dukkan.com>", "https://dukkan.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &data.User{FirstName: "Jane <b>", Email: "jane@example.com"}
+
+	tests := []struct {
+		name    string
+		send    func() error
+		to      string
+		subject string
+		// every part has to contain these.
+		contains []string
+	}{
+		{
+			name:     "activation",
+			send:     func() error { return sender.ActivationEmail(context.Background(), user, "ACT123") },
+			to:       "jane@example.com",
+			subject:  "Dukkan - Account Activation Code",
+			contains: []string{"ACT123", "https://dukkan.com/tokens/activation"},
+		},
+		{
+			name:     "password reset",
+			send:     func() error { return sender.PasswordResetEmail(context.Background(), user, "RESET456") },
+			to:       "jane@example.com",
+			subject:  "Dukkan - Password Reset Code",
+			contains: []string{"RESET456", "https://dukkan.com/users/password"},
+		},
+		{
+			name: "email change",
+			send: func() error {
+				return sender.EmailChangeEmail(context.Background(), user, "new@example.com", "CHANGE789")
+			},
+			to:       "new@example.com",
+			subject:  "Dukkan - Confirm Your New Email",
+			contains: []string{"CHANGE789", "new@example.com"},
+		},
+		{
+			name:     "email change notice",
+			send:     func() error { return sender.EmailChangeNoticeEmail(context.Background(), user, "new@example.com") },
+			to:       "jane@example.com",
+			subject:  "Dukkan - Your Email Is Being Changed",
+			contains: []string{"new@example.com", "https://dukkan.com/tokens/password-reset"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.Reset()
+			if err := tt.send(); err != nil {
+				t.Fatal(err)
+			}
+
+			msgs := srv.Messages()
+			if len(msgs) != 1 {
+				t.Fatalf("got %d messages, want 1", len(msgs))
+			}
+			msg := msgs[0]
+
+			if msg.From != "no-reply@dukkan.com" {
+				t.Errorf("From = %q", msg.From)
+			}
+			if len(msg.To) != 1 || msg.To[0] != tt.to {
+				t.Errorf("To = %v, want %s", msg.To, tt.to)
+			}
+			if msg.Subject != tt.subject {
+				t.Errorf("Subject = %q, want %q", msg.Subject, tt.subject)
+			}
+
+			for _, s := range tt.contains {
+				if !strings.Contains(msg.Text, s) {
+					t.Errorf("Text does not contain %q:\n%s", s, msg.Text)
+				}
+				if !strings.Contains(msg.HTML, s) {
+					t.Errorf("HTML does not contain %q:\n%s", s, msg.HTML)
+				}
+			}
+
+			// names are user input, only the HTML part escapes them.
+			if !strings.Contains(msg.Text, "Hi Jane <b>,") {
+				t.Errorf("Text does not greet the user:\n%s", msg.Text)
+			}
+			if !strings.Contains(msg.HTML, "Jane &lt;b&gt;") || strings.Contains(msg.HTML, "Jane <b>") {
+				t.Errorf("HTML does not escape the name of the user:\n%s", msg.HTML)
+			}
+		})
+	}
+}
+
+func TestSMTPMailerStopsWhenCanceled(t *testing.T) {
+	// the server accepts connections and never greets the client.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	mailer := email.SMTPMailer{Host: "127.0.0.1", Port: addr.Port}
+	msg := &email.Message{From: "no-reply@dukkan.com", To: []string{"jane@example.com"}, Subject: "hi", Text: "hi"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	if err := mailer.Send(ctx, msg); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Send() error = %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Send() returned after %s", d)
+	}
+
+	mailer.Timeout = 100 * time.Millisecond
+	if err := mailer.Send(context.Background(), msg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Send() error = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,52 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// LogMailer logs emails instead of sending them, it is meant for
+// development. Emails hold codes which log in or reset passwords, so the
+// text part is only logged when Body is set.
+type LogMailer struct {
+	Logger *zap.SugaredLogger
+	Body   bool
+}
+
+func (m LogMailer) Send(ctx context.Context, msg *Message) error {
+	fields := []interface{}{"from", msg.From, "to", strings.Join(msg.To, ", "), "subject", msg.Subject}
+	if m.Body {
+		fields = append(fields, "text", msg.Text)
+	}
+	m.Logger.Infow("email not sent, there is no SMTP host", fields...)
+	return nil
+}
+
+// FileMailer writes every email as an .eml file into Dir, the files can be
+// opened with any mail client.
+type FileMailer struct {
+	Dir string
+}
+
+func (m FileMailer) Send(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000000"), strings.Join(msg.To, ","))
+	return os.WriteFile(filepath.Join(m.Dir, name), body, 0o644)
+}
@@ -0,0 +1,195 @@
+// Package smtptest provides a local SMTP server which captures the messages it
+// receives, so tests can send emails through email.SMTPMailer and assert on
+// what was sent.
+//
+//	srv, err := smtptest.NewServer()
+//	defer srv.Close()
+//	mailer := email.SMTPMailer{Host: srv.Host, Port: srv.Port}
+//	...
+//	msgs := srv.Messages()
+package smtptest
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// Message is a captured email, Text and HTML are the decoded parts.
+type Message struct {
+	From    string
+	To      []string
+	Header  mail.Header
+	Subject string
+	Text    string
+	HTML    string
+	Raw     []byte
+}
+
+type Server struct {
+	Host string
+	Port int
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewServer() starts a server on a random port of 127.0.0.1.
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	addr := l.Addr().(*net.TCPAddr)
+	s := &Server{Host: addr.IP.String(), Port: addr.Port, listener: l}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Messages() returns the messages received so far.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Reset() forgets the received messages.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+}
+
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(textproto.NewConn(conn))
+		}()
+	}
+}
+
+func (s *Server) handle(c *textproto.Conn) {
+	var from string
+	var to []string
+
+	c.PrintfLine("220 smtptest ready")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		arg := strings.TrimSpace(line[len(verb):])
+
+		switch verb {
+		case "EHLO":
+			c.PrintfLine("250-smtptest")
+			c.PrintfLine("250-8BITMIME")
+			c.PrintfLine("250 AUTH PLAIN")
+		case "HELO":
+			c.PrintfLine("250 smtptest")
+		case "AUTH":
+			// any credentials are accepted.
+			c.PrintfLine("235 authenticated")
+		case "MAIL":
+			from = address(arg)
+			to = nil
+			c.PrintfLine("250 ok")
+		case "RCPT":
+			to = append(to, address(arg))
+			c.PrintfLine("250 ok")
+		case "DATA":
+			c.PrintfLine("354 go ahead")
+			raw, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, parse(from, to, raw))
+			s.mu.Unlock()
+			c.PrintfLine("250 ok")
+		case "RSET":
+			from, to = "", nil
+			c.PrintfLine("250 ok")
+		case "NOOP":
+			c.PrintfLine("250 ok")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("502 command not implemented")
+		}
+	}
+}
+
+// address() returns the address of "FROM:<a@b.c>" and "TO:<a@b.c>".
+func address(arg string) string {
+	if i := strings.Index(arg, "<"); i >= 0 {
+		if j := strings.Index(arg[i:], ">"); j >= 0 {
+			return arg[i+1 : i+j]
+		}
+	}
+	return arg
+}
+
+func parse(from string, to []string, raw []byte) Message {
+	msg := Message{From: from, To: to, Raw: raw}
+
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return msg
+	}
+	msg.Header = m.Header
+	msg.Subject, _ = new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		b, _ := io.ReadAll(m.Body)
+		msg.Text = string(b)
+		return msg
+	}
+
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		// quoted-printable parts are decoded by the multipart reader.
+		b, _ := io.ReadAll(p)
+
+		switch t, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type")); t {
+		case "text/plain":
+			msg.Text = string(b)
+		case "text/html":
+			msg.HTML = string(b)
+		}
+	}
+	return msg
+}
@@ -0,0 +1,225 @@
+// Package jobs runs the jobs of the Postgres backed queue in data.JobModel.
+// Jobs survive restarts, failed jobs are retried with exponential backoff
+// and end up dead once they used up their attempts.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kubil6y/dukkan-go/internal/data"
+	"go.uber.org/zap"
+)
+
+// HandlerFunc runs a job, payload is the JSON the job was enqueued with.
+// A returned error fails the attempt.
+type HandlerFunc func(ctx context.Context, payload []byte) error
+
+type Worker struct {
+	jobs     data.JobModel
+	logger   *zap.SugaredLogger
+	handlers map[string]HandlerFunc
+
+	// jobCtx is the parent of the job contexts, Abort() cancels it.
+	jobCtx  context.Context
+	abort   context.CancelFunc
+	mu      sync.Mutex
+	running map[int64]string
+
+	// Concurrency is the number of jobs run at the same time.
+	Concurrency int
+	// PollInterval is how long an idle worker waits before looking for jobs.
+	PollInterval time.Duration
+	// Timeout is how long a job can run, jobs locked for longer are
+	// considered abandoned and run again.
+	Timeout time.Duration
+	// DeadRetention is how long dead jobs are kept to be inspected and
+	// retried, older ones are deleted. Zero keeps them forever.
+	DeadRetention time.Duration
+}
+
+func NewWorker(jobs data.JobModel, logger *zap.SugaredLogger) *Worker {
+	jobCtx, abort := context.WithCancel(context.Background())
+	return &Worker{
+		jobs:          jobs,
+		logger:        logger,
+		handlers:      make(map[string]HandlerFunc),
+		jobCtx:        jobCtx,
+		abort:         abort,
+		running:       make(map[int64]string),
+		Concurrency:   2,
+		PollInterval:  time.Second,
+		Timeout:       5 * time.Minute,
+		DeadRetention: 7 * 24 * time.Hour,
+	}
+}
+
+// Handle() registers the handler of a job kind, it is not safe to call
+// once Run() started.
+func (w *Worker) Handle(kind string, fn HandlerFunc) {
+	w.handlers[kind] = fn
+}
+
+// Run() works on jobs until ctx is done, then it waits for the running jobs.
+// Running jobs are not canceled by ctx, they get their own Timeout or are
+// canceled by Abort().
+func (w *Worker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < w.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.cleanup(ctx)
+	}()
+
+	wg.Wait()
+}
+
+func (w *Worker) loop(ctx context.Context) {
+	for {
+		// drain the queue before sleeping again.
+		for ctx.Err() == nil {
+			job, err := w.jobs.Claim()
+			if err != nil {
+				if !errors.Is(err, data.ErrRecordNotFound) {
+					w.logger.Errorw("claiming job failed", "error", err)
+				}
+				break
+			}
+			w.run(job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.PollInterval):
+		}
+	}
+}
+
+// Abort() cancels the contexts of the running jobs, e.g. when they did not
+// finish in time on shutdown. Jobs which are cut off fail their attempt,
+// jobs which never return are requeued once they are stale.
+func (w *Worker) Abort() {
+	w.abort()
+}
+
+// Running() returns the running jobs as "kind #id".
+func (w *Worker) Running() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	running := make([]string, 0, len(w.running))
+	for id, kind := range w.running {
+		running = append(running, fmt.Sprintf("%s #%d", kind, id))
+	}
+	sort.Strings(running)
+	return running
+}
+
+func (w *Worker) run(job *data.Job) {
+	ctx, cancel := context.WithTimeout(w.jobCtx, w.Timeout)
+	defer cancel()
+
+	w.mu.Lock()
+	w.running[job.ID] = job.Kind
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		delete(w.running, job.ID)
+		w.mu.Unlock()
+	}()
+
+	err := w.call(ctx, job)
+	if err == nil {
+		if err := w.jobs.Complete(job); err != nil {
+			w.logger.Errorw("completing job failed", "job", job.ID, "kind", job.Kind, "error", err)
+		}
+		return
+	}
+
+	if err := w.jobs.Fail(job, err, time.Now().Add(Backoff(job.Attempts))); err != nil {
+		w.logger.Errorw("failing job failed", "job", job.ID, "kind", job.Kind, "error", err)
+		return
+	}
+	if job.Status == data.JobDead {
+		w.logger.Errorw("job is dead", "job", job.ID, "kind", job.Kind, "attempts", job.Attempts, "error", err)
+	} else {
+		w.logger.Warnw("job failed", "job", job.ID, "kind", job.Kind, "attempts", job.Attempts, "error", err)
+	}
+}
+
+// call() runs the handler, a panic fails the attempt like an error.
+func (w *Worker) call(ctx context.Context, job *data.Job) (err error) {
+	fn, ok := w.handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("no handler for job kind %q", job.Kind)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx, job.Payload)
+}
+
+// cleanup() requeues stale jobs and deletes dead jobs past their retention
+// once a minute.
+func (w *Worker) cleanup(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := w.jobs.RequeueStale(w.Timeout + time.Minute)
+			if err != nil {
+				w.logger.Errorw("requeueing stale jobs failed", "error", err)
+			} else if n > 0 {
+				w.logger.Warnw("requeued stale jobs", "count", n)
+			}
+
+			if w.DeadRetention <= 0 {
+				continue
+			}
+			n, err = w.jobs.DeleteDead(time.Now().Add(-w.DeadRetention))
+			if err != nil {
+				w.logger.Errorw("deleting dead jobs failed", "error", err)
+			} else if n > 0 {
+				w.logger.Infow("deleted dead jobs", "count", n)
+			}
+		}
+	}
+}
+
+// Backoff() returns the delay before the next attempt: 10s, 20s, 40s...
+// up to an hour, with up to 20% jitter so failed jobs don't retry in lockstep.
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	d := time.Hour
+	if attempts < 10 {
+		d = 10 * time.Second << uint(attempts-1)
+		if d > time.Hour {
+			d = time.Hour
+		}
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
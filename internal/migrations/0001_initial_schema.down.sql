@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS
+	"order_items",
+	"orders",
+	"ratings",
+	"reviews",
+	"products",
+	"categories",
+	"tokens",
+	"users",
+	"roles";
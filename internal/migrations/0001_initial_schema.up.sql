@@ -0,0 +1,28 @@
+-- The schema as gorm's AutoMigrate created it before the versioned
+-- migrations. Every statement is guarded with IF NOT EXISTS, so databases
+-- which AutoMigrate created adopt it. The later migrations are guarded the
+-- same way, they bring such databases up to date no matter how far
+-- AutoMigrate got.
+
+CREATE TABLE IF NOT EXISTS "roles" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"name" text NOT NULL,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_roles_name" ON "roles" ("name");
+
+CREATE TABLE IF NOT EXISTS "users" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"first_name" text NOT NULL,"last_name" text NOT NULL,"email" text NOT NULL,"password" bytea NOT NULL,"address" text NOT NULL,"is_activated" boolean NOT NULL DEFAULT false,"role_id" bigint NOT NULL,PRIMARY KEY ("id"),CONSTRAINT "fk_users_role" FOREIGN KEY ("role_id") REFERENCES "roles"("id"));
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_users_email" ON "users" ("email");
+
+CREATE TABLE IF NOT EXISTS "tokens" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"scope" text NOT NULL,"hash" bytea NOT NULL,"expiry" timestamptz,"user_id" bigint NOT NULL,PRIMARY KEY ("id"),CONSTRAINT "fk_users_tokens" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE);
+
+CREATE TABLE IF NOT EXISTS "categories" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"name" text NOT NULL,"slug" text NOT NULL,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_categories_name" ON "categories" ("name");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_categories_slug" ON "categories" ("slug");
+
+CREATE TABLE IF NOT EXISTS "products" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"name" text NOT NULL,"slug" text NOT NULL,"description" text NOT NULL,"brand" text NOT NULL,"image" text NOT NULL,"price" decimal NOT NULL,"count" bigint NOT NULL,"category_id" bigint NOT NULL,PRIMARY KEY ("id"),CONSTRAINT "fk_categories_products" FOREIGN KEY ("category_id") REFERENCES "categories"("id") ON DELETE SET NULL);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_products_slug" ON "products" ("slug");
+
+CREATE TABLE IF NOT EXISTS "reviews" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"text" text NOT NULL,"user_id" bigint NOT NULL,"product_id" bigint NOT NULL,PRIMARY KEY ("id"),CONSTRAINT "fk_users_reviews" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE SET NULL,CONSTRAINT "fk_products_reviews" FOREIGN KEY ("product_id") REFERENCES "products"("id") ON DELETE CASCADE);
+
+CREATE TABLE IF NOT EXISTS "ratings" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"value" bigint NOT NULL,"user_id" bigint NOT NULL,"product_id" bigint NOT NULL,PRIMARY KEY ("id"),CONSTRAINT "fk_users_ratings" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE SET NULL,CONSTRAINT "fk_products_ratings" FOREIGN KEY ("product_id") REFERENCES "products"("id") ON DELETE CASCADE,CONSTRAINT "chk_ratings_value" CHECK (value>=0 and value<=5));
+
+CREATE TABLE IF NOT EXISTS "orders" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"user_id" bigint NOT NULL,"payment_method" text NOT NULL,"is_paid" boolean NOT NULL,"is_delivered" boolean NOT NULL,"paid_at" timestamptz NOT NULL,"total_price" decimal NOT NULL,"delivered_at" timestamptz NOT NULL,PRIMARY KEY ("id"),CONSTRAINT "fk_users_orders" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE SET NULL);
+
+CREATE TABLE IF NOT EXISTS "order_items" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"order_id" bigint NOT NULL,"product_id" bigint NOT NULL,"quantity" bigint NOT NULL,PRIMARY KEY ("id"),CONSTRAINT "fk_order_items_product" FOREIGN KEY ("product_id") REFERENCES "products"("id"),CONSTRAINT "fk_orders_order_items" FOREIGN KEY ("order_id") REFERENCES "orders"("id") ON DELETE CASCADE);
@@ -0,0 +1,7 @@
+ALTER TABLE "orders" ADD COLUMN "is_paid" boolean NOT NULL DEFAULT false, ADD COLUMN "is_delivered" boolean NOT NULL DEFAULT false;
+UPDATE "orders" SET "is_paid" = true WHERE "status" IN ('paid', 'processing', 'shipped', 'delivered', 'refunded');
+UPDATE "orders" SET "is_delivered" = true WHERE "status" = 'delivered';
+ALTER TABLE "orders" ALTER COLUMN "is_paid" DROP DEFAULT, ALTER COLUMN "is_delivered" DROP DEFAULT;
+
+DROP TABLE IF EXISTS "order_transitions";
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "cancel_reason", DROP COLUMN IF EXISTS "status";
@@ -0,0 +1,17 @@
+ALTER TABLE "orders" ADD COLUMN IF NOT EXISTS "status" text NOT NULL DEFAULT 'pending';
+ALTER TABLE "orders" ADD COLUMN IF NOT EXISTS "cancel_reason" text;
+CREATE INDEX IF NOT EXISTS "idx_orders_status" ON "orders" ("status");
+
+CREATE TABLE IF NOT EXISTS "order_transitions" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"order_id" bigint NOT NULL,"from_status" text NOT NULL,"to_status" text NOT NULL,"user_id" bigint NOT NULL,"note" text NOT NULL,PRIMARY KEY ("id"),CONSTRAINT "fk_orders_transitions" FOREIGN KEY ("order_id") REFERENCES "orders"("id") ON DELETE CASCADE);
+CREATE INDEX IF NOT EXISTS "idx_order_transitions_order_id" ON "order_transitions" ("order_id");
+
+-- the paid and delivered flags become statuses.
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'orders' AND column_name = 'is_paid') THEN
+		UPDATE "orders" SET "status" = 'delivered' WHERE "is_delivered";
+		UPDATE "orders" SET "status" = 'paid' WHERE "is_paid" AND NOT "is_delivered";
+		ALTER TABLE "orders" DROP COLUMN "is_paid", DROP COLUMN "is_delivered";
+	END IF;
+END $$;
@@ -0,0 +1,15 @@
+ALTER TABLE "products" ADD COLUMN "price" decimal NOT NULL DEFAULT 0;
+UPDATE "products" SET "price" = "price_amount" / 100.0;
+ALTER TABLE "products" ALTER COLUMN "price" DROP DEFAULT;
+
+ALTER TABLE "orders" ADD COLUMN "total_price" decimal NOT NULL DEFAULT 0;
+UPDATE "orders" SET "total_price" = "total_price_amount" / 100.0;
+ALTER TABLE "orders" ALTER COLUMN "total_price" DROP DEFAULT;
+
+ALTER TABLE "order_items"
+	DROP COLUMN "unit_price_amount",
+	DROP COLUMN "unit_price_currency",
+	DROP COLUMN "line_total_amount",
+	DROP COLUMN "line_total_currency";
+ALTER TABLE "orders" DROP COLUMN "total_price_amount", DROP COLUMN "total_price_currency";
+ALTER TABLE "products" DROP COLUMN "price_amount", DROP COLUMN "price_currency";
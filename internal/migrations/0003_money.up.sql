@@ -0,0 +1,36 @@
+-- prices are stored in minor units, order items keep a snapshot of the
+-- price they were ordered for.
+ALTER TABLE "products"
+	ADD COLUMN IF NOT EXISTS "price_amount" bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS "price_currency" varchar(3) NOT NULL DEFAULT 'TRY';
+ALTER TABLE "orders"
+	ADD COLUMN IF NOT EXISTS "total_price_amount" bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS "total_price_currency" varchar(3) NOT NULL DEFAULT 'TRY';
+ALTER TABLE "order_items"
+	ADD COLUMN IF NOT EXISTS "unit_price_amount" bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS "unit_price_currency" varchar(3) NOT NULL DEFAULT 'TRY',
+	ADD COLUMN IF NOT EXISTS "line_total_amount" bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS "line_total_currency" varchar(3) NOT NULL DEFAULT 'TRY';
+
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'products' AND column_name = 'price') THEN
+		UPDATE "products" SET "price_amount" = ROUND("price" * 100);
+		ALTER TABLE "products" DROP COLUMN "price";
+	END IF;
+
+	-- old orders have no price snapshot, the current product prices are the
+	-- best guess.
+	IF EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'orders' AND column_name = 'total_price') THEN
+		UPDATE "order_items" oi
+			SET "unit_price_amount" = p."price_amount",
+				"unit_price_currency" = p."price_currency",
+				"line_total_amount" = p."price_amount" * oi."quantity",
+				"line_total_currency" = p."price_currency"
+			FROM "products" p WHERE p."id" = oi."product_id";
+		UPDATE "orders" SET "total_price_amount" = ROUND("total_price" * 100);
+		ALTER TABLE "orders" DROP COLUMN "total_price";
+	END IF;
+END $$;
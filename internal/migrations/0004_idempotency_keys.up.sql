@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS "idempotency_keys" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"key" text NOT NULL,"user_id" bigint NOT NULL,"request_hash" bytea NOT NULL,"status_code" bigint NOT NULL,"content_type" text NOT NULL,"response_body" bytea,"expiry" timestamptz NOT NULL,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_idempotency_keys_expiry" ON "idempotency_keys" ("expiry");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_idempotency_user_key" ON "idempotency_keys" ("key","user_id");
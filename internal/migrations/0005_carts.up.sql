@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS "carts" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"user_id" bigint NOT NULL,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_carts_user_id" ON "carts" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "cart_items" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"cart_id" bigint NOT NULL,"product_id" bigint NOT NULL,"quantity" bigint NOT NULL,PRIMARY KEY ("id"),CONSTRAINT "fk_carts_cart_items" FOREIGN KEY ("cart_id") REFERENCES "carts"("id") ON DELETE CASCADE,CONSTRAINT "fk_cart_items_product" FOREIGN KEY ("product_id") REFERENCES "products"("id") ON DELETE CASCADE);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_cart_product" ON "cart_items" ("cart_id","product_id");
@@ -0,0 +1,9 @@
+-- carts with several variants of one product keep only one of them.
+DELETE FROM "cart_items" a USING "cart_items" b
+	WHERE a."cart_id" = b."cart_id" AND a."product_id" = b."product_id" AND a."id" > b."id";
+DROP INDEX IF EXISTS "idx_cart_product";
+ALTER TABLE "cart_items" DROP COLUMN "variant_id";
+CREATE UNIQUE INDEX "idx_cart_product" ON "cart_items" ("cart_id","product_id");
+
+ALTER TABLE "order_items" DROP COLUMN "variant_id", DROP COLUMN "sku";
+DROP TABLE IF EXISTS "product_variants";
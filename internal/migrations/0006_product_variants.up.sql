@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS "product_variants" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"product_id" bigint NOT NULL,"sku" text NOT NULL,"options" jsonb NOT NULL,"price_override" bigint,"count" bigint NOT NULL,PRIMARY KEY ("id"),CONSTRAINT "fk_products_variants" FOREIGN KEY ("product_id") REFERENCES "products"("id") ON DELETE CASCADE);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_product_variants_sku" ON "product_variants" ("sku");
+CREATE INDEX IF NOT EXISTS "idx_product_variants_product_id" ON "product_variants" ("product_id");
+
+ALTER TABLE "order_items"
+	ADD COLUMN IF NOT EXISTS "variant_id" bigint CONSTRAINT "fk_order_items_variant" REFERENCES "product_variants"("id") ON DELETE SET NULL,
+	ADD COLUMN IF NOT EXISTS "sku" text;
+
+-- a cart can hold several variants of one product. AutoMigrate never
+-- replaced the index, so it is recreated with the variant in any case.
+ALTER TABLE "cart_items" ADD COLUMN IF NOT EXISTS "variant_id" bigint NOT NULL DEFAULT 0;
+DROP INDEX IF EXISTS "idx_cart_product";
+CREATE UNIQUE INDEX "idx_cart_product" ON "cart_items" ("cart_id","product_id","variant_id");
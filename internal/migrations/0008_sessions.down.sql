@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS "idx_tokens_family_id";
+ALTER TABLE "tokens"
+	DROP COLUMN "last_used_at",
+	DROP COLUMN "user_agent",
+	DROP COLUMN "ip",
+	DROP COLUMN "family_id",
+	DROP COLUMN "used_at";
@@ -0,0 +1,9 @@
+-- authentication tokens double as sessions, refresh tokens which were
+-- rotated out share the family of their successor.
+ALTER TABLE "tokens"
+	ADD COLUMN IF NOT EXISTS "last_used_at" timestamptz,
+	ADD COLUMN IF NOT EXISTS "user_agent" text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS "ip" text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS "family_id" text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS "used_at" timestamptz;
+CREATE INDEX IF NOT EXISTS "idx_tokens_family_id" ON "tokens" ("family_id");
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS "recovery_codes";
+ALTER TABLE "users"
+	DROP COLUMN "two_factor_enabled",
+	DROP COLUMN "totp_secret",
+	DROP COLUMN "totp_last_step";
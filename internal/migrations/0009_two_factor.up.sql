@@ -0,0 +1,7 @@
+ALTER TABLE "users"
+	ADD COLUMN IF NOT EXISTS "two_factor_enabled" boolean NOT NULL DEFAULT false,
+	ADD COLUMN IF NOT EXISTS "totp_secret" text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS "totp_last_step" bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS "recovery_codes" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"user_id" bigint NOT NULL,"hash" bytea NOT NULL,"used_at" timestamptz,PRIMARY KEY ("id"),CONSTRAINT "fk_recovery_codes_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE);
+CREATE INDEX IF NOT EXISTS "idx_recovery_codes_user_id" ON "recovery_codes" ("user_id");
@@ -0,0 +1,5 @@
+-- the permissions themselves are created on startup, see syncPermissions().
+CREATE TABLE IF NOT EXISTS "permissions" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"code" text NOT NULL,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_permissions_code" ON "permissions" ("code");
+
+CREATE TABLE IF NOT EXISTS "role_permissions" ("role_id" bigint,"permission_id" bigint,PRIMARY KEY ("role_id","permission_id"),CONSTRAINT "fk_role_permissions_role" FOREIGN KEY ("role_id") REFERENCES "roles"("id") ON DELETE CASCADE,CONSTRAINT "fk_role_permissions_permission" FOREIGN KEY ("permission_id") REFERENCES "permissions"("id") ON DELETE CASCADE);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS "login_attempts" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"email" text NOT NULL,"failures" bigint NOT NULL DEFAULT 0,"last_failed_at" timestamptz,"locked_until" timestamptz,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_login_attempts_email" ON "login_attempts" ("email");
+
+CREATE TABLE IF NOT EXISTS "audit_events" ("id" bigserial,"created_at" timestamptz NOT NULL,"action" text NOT NULL,"actor_id" bigint,"email" text NOT NULL DEFAULT '',"ip" text NOT NULL DEFAULT '',"details" text NOT NULL DEFAULT '',PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_audit_events_action" ON "audit_events" ("action");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_created_at" ON "audit_events" ("created_at");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_email" ON "audit_events" ("email");
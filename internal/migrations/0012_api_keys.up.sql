@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS "api_keys" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"name" text NOT NULL,"prefix" text NOT NULL,"hash" bytea NOT NULL,"expiry" timestamptz,"last_used_at" timestamptz,"created_by_id" bigint NOT NULL,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_api_keys_hash" ON "api_keys" ("hash");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_api_keys_prefix" ON "api_keys" ("prefix");
+
+CREATE TABLE IF NOT EXISTS "api_key_permissions" ("api_key_id" bigint,"permission_id" bigint,PRIMARY KEY ("api_key_id","permission_id"),CONSTRAINT "fk_api_key_permissions_api_key" FOREIGN KEY ("api_key_id") REFERENCES "api_keys"("id") ON DELETE CASCADE,CONSTRAINT "fk_api_key_permissions_permission" FOREIGN KEY ("permission_id") REFERENCES "permissions"("id") ON DELETE CASCADE);
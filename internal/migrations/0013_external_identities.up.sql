@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS "external_identities" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"user_id" bigint NOT NULL,"provider" text NOT NULL,"subject" text NOT NULL,"email" text NOT NULL,PRIMARY KEY ("id"),CONSTRAINT "fk_external_identities_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_identity_provider_subject" ON "external_identities" ("provider","subject");
+CREATE INDEX IF NOT EXISTS "idx_external_identities_user_id" ON "external_identities" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "o_auth_states" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"hash" bytea NOT NULL,"provider" text NOT NULL,"nonce" text NOT NULL,"code_verifier" text NOT NULL,"expiry" timestamptz NOT NULL,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_o_auth_states_expiry" ON "o_auth_states" ("expiry");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_o_auth_states_hash" ON "o_auth_states" ("hash");
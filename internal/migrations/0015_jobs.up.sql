@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS "jobs" ("id" bigserial,"created_at" timestamptz NOT NULL,"updated_at" timestamptz NOT NULL,"kind" text NOT NULL,"payload" bytea NOT NULL,"status" text NOT NULL DEFAULT 'pending',"run_at" timestamptz NOT NULL,"attempts" bigint NOT NULL DEFAULT 0,"max_attempts" bigint NOT NULL,"locked_at" timestamptz,"last_error" text NOT NULL DEFAULT '',PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_jobs_status_run_at" ON "jobs" ("status","run_at");
+CREATE INDEX IF NOT EXISTS "idx_jobs_kind" ON "jobs" ("kind");
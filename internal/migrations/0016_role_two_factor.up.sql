@@ -0,0 +1 @@
+ALTER TABLE "roles" ADD COLUMN IF NOT EXISTS "require_two_factor" boolean NOT NULL DEFAULT false;
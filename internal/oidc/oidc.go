@@ -0,0 +1,51 @@
+// Package oidc implements the OpenID Connect authorization code flow with PKCE.
+// Identity providers are used through the Provider interface, so providers
+// which are not OIDC compliant can be plugged in next to NewProvider().
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// Identity is the user as the identity provider knows them.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+}
+
+type Provider interface {
+	// Name() is the name used in routes, e.g. "google".
+	Name() string
+
+	// AuthCodeURL() returns the url the user logs in at.
+	AuthCodeURL(state, nonce, codeChallenge string) (string, error)
+
+	// Exchange() trades the code from the redirect for the identity of the user,
+	// nonce and codeVerifier must be the ones the flow was started with.
+	Exchange(ctx context.Context, code, nonce, codeVerifier string) (*Identity, error)
+}
+
+// RandomString() returns n random bytes encoded with unpadded base64url,
+// it is used for states, nonces and PKCE verifiers.
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge() returns the S256 PKCE challenge of the verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
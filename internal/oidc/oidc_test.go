@@ -0,0 +1,268 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID = "dukkan"
+	testKid      = "key-1"
+)
+
+// fakeIssuer is a local OIDC issuer with discovery, JWKS and token endpoints.
+// The token endpoint checks the PKCE verifier against the challenge of the
+// code and answers with idToken.
+type fakeIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu         sync.Mutex
+	challenges map[string]string
+	idToken    string
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIssuer{key: key, challenges: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                f.URL,
+			AuthorizationEndpoint: f.URL + "/authorize",
+			TokenEndpoint:         f.URL + "/token",
+			JWKSURI:               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": testKid,
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+			http.Error(w, "invalid_request", http.StatusBadRequest)
+			return
+		}
+
+		f.mu.Lock()
+		challenge, ok := f.challenges[r.PostForm.Get("code")]
+		idToken := f.idToken
+		f.mu.Unlock()
+
+		if !ok || CodeChallenge(r.PostForm.Get("code_verifier")) != challenge {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// authorize() plays the login of the user at the authorization endpoint and
+// returns the code of the redirect.
+func (f *fakeIssuer) authorize(t *testing.T, authURL string) string {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != testClientID {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+
+	code := "code-" + q.Get("state")
+	f.mu.Lock()
+	f.challenges[code] = q.Get("code_challenge")
+	f.mu.Unlock()
+	return code
+}
+
+// sign() returns an RS256 id token of the claims.
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestExchange(t *testing.T) {
+	f := newFakeIssuer(t)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":            f.URL,
+			"sub":            "248289761001",
+			"aud":            []string{testClientID, "other"},
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"nonce":          "nonce",
+			"email":          "Jane@Example.com",
+			"email_verified": "true",
+			"name":           "Jane Doe",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		key     *rsa.PrivateKey
+		kid     string
+		claims  func(c map[string]interface{})
+		nonce   string
+		wantErr bool
+		// errIs is checked when it is set, unknown keys have their own error.
+		errIs error
+	}{
+		{name: "valid"},
+		{name: "bad nonce", nonce: "other", wantErr: true, errIs: ErrInvalidIDToken},
+		{name: "bad issuer", claims: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, wantErr: true, errIs: ErrInvalidIDToken},
+		{name: "bad audience", claims: func(c map[string]interface{}) { c["aud"] = "other" }, wantErr: true, errIs: ErrInvalidIDToken},
+		{name: "expired", claims: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Minute).Unix() }, wantErr: true, errIs: ErrInvalidIDToken},
+		{name: "unknown kid", kid: "key-2", wantErr: true},
+		{name: "bad signature", key: otherKey, wantErr: true, errIs: ErrInvalidIDToken},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewProvider(Config{
+				Name:        "fake",
+				Issuer:      f.URL,
+				ClientID:    testClientID,
+				RedirectURL: "http://localhost/callback",
+			})
+
+			key, kid, nonce := f.key, testKid, "nonce"
+			if tt.key != nil {
+				key = tt.key
+			}
+			if tt.kid != "" {
+				kid = tt.kid
+			}
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+			claims := validClaims()
+			if tt.claims != nil {
+				tt.claims(claims)
+			}
+
+			f.mu.Lock()
+			f.idToken = sign(t, key, kid, claims)
+			f.mu.Unlock()
+
+			verifier, err := RandomString(32)
+			if err != nil {
+				t.Fatal(err)
+			}
+			authURL, err := p.AuthCodeURL(string(rune('a'+i)), "nonce", CodeChallenge(verifier))
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := f.authorize(t, authURL)
+
+			identity, err := p.Exchange(context.Background(), code, nonce, verifier)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Exchange() accepted an invalid id token: %+v", identity)
+				}
+				if tt.errIs != nil && !errors.Is(err, tt.errIs) {
+					t.Fatalf("Exchange() error = %v, want %v", err, tt.errIs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := Identity{
+				Subject:       "248289761001",
+				Email:         "jane@example.com",
+				EmailVerified: true,
+				FirstName:     "Jane",
+				LastName:      "Doe",
+			}
+			if *identity != want {
+				t.Errorf("identity = %+v, want %+v", *identity, want)
+			}
+		})
+	}
+}
+
+func TestExchangeChecksCodeVerifier(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := NewProvider(Config{Name: "fake", Issuer: f.URL, ClientID: testClientID})
+
+	f.idToken = sign(t, f.key, testKid, map[string]interface{}{
+		"iss": f.URL, "sub": "1", "aud": testClientID, "exp": time.Now().Add(time.Minute).Unix(), "nonce": "nonce",
+	})
+
+	authURL, err := p.AuthCodeURL("state", "nonce", CodeChallenge("verifier"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := f.authorize(t, authURL)
+
+	if _, err := p.Exchange(context.Background(), code, "nonce", "other verifier"); err == nil {
+		t.Fatal("Exchange() succeeded with the wrong code verifier")
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := NewProvider(Config{Name: "fake", Issuer: f.URL, ClientID: testClientID}).(*provider)
+
+	claims := map[string]interface{}{
+		"iss": f.URL, "sub": "1", "aud": testClientID, "exp": time.Now().Add(time.Minute).Unix(), "nonce": "nonce",
+	}
+	token := sign(t, f.key, testKid, claims)
+
+	for name, idToken := range map[string]string{
+		"not a jwt":          "abc",
+		"tampered signature": token[:len(token)-10] + "AAAAAAAAAA",
+	} {
+		if _, err := p.verify(context.Background(), idToken, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("%s: verify() error = %v, want ErrInvalidIDToken", name, err)
+		}
+	}
+}
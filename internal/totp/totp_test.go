@@ -0,0 +1,88 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of RFC 6238 Appendix B, "12345678901234567890".
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238(t *testing.T) {
+	// Appendix B lists 8 digit codes, the 6 digit codes are their last digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		step := Step(time.Unix(tt.unix, 0))
+		got, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tt.want[len(tt.want)-Digits:]; got != want {
+			t.Errorf("Code() at T=%d = %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestValidateStepWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"two steps behind", -2, false},
+		{"one step behind", -1, true},
+		{"current step", 0, true},
+		{"one step ahead", 1, true},
+		{"two steps ahead", 2, false},
+	}
+
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, current+tt.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		step, ok := Validate(rfcSecret, code, now)
+		if ok != tt.ok {
+			t.Errorf("%s: Validate() ok = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		// callers refuse replays by the returned step, it has to be the
+		// step the code belongs to.
+		if ok && step != current+tt.offset {
+			t.Errorf("%s: Validate() step = %d, want %d", tt.name, step, current+tt.offset)
+		}
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+	code, err := Code(rfcSecret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []string{"", code[:Digits-1], code + "0", "abcdef"} {
+		if _, ok := Validate(rfcSecret, c, now); ok {
+			t.Errorf("Validate(%q) accepted a malformed code", c)
+		}
+	}
+	if _, ok := Validate(rfcSecret, " "+code+" ", now); !ok {
+		t.Errorf("Validate() refused a code with surrounding spaces")
+	}
+}